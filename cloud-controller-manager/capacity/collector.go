@@ -0,0 +1,205 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package capacity exposes how close the load balancers and the security group managed by
+// the Azure cloud provider are to their limits, so that alerts could fire before creating
+// or updating services fails.
+package capacity
+
+import (
+	"context"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2017-09-01/network"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog"
+	azureprovider "k8s.io/kubernetes/pkg/cloudprovider/providers/azure"
+)
+
+const (
+	// minimumPriority and maximumPriority are the range of security rule priorities allocated
+	// by the Azure cloud provider for services, see getNextAvailablePriority.
+	minimumPriority = 500
+	maximumPriority = 4096
+)
+
+// Collector periodically lists the load balancers and the security group of the cluster, and
+// reports their rule, frontend IP configuration and priority usage as gauges.
+type Collector struct {
+	resourceGroup        string
+	securityGroupName    string
+	loadBalancerClient   azureprovider.LoadBalancersClient
+	securityGroupsClient azureprovider.SecurityGroupsClient
+
+	// refreshPeriod is the interval at which the load balancers and the security group are listed.
+	refreshPeriod time.Duration
+
+	loadBalancerRules                *prometheus.GaugeVec
+	loadBalancerRulesLimit           prometheus.Gauge
+	loadBalancerFrontendIPs          *prometheus.GaugeVec
+	securityGroupRules               *prometheus.GaugeVec
+	securityGroupAvailablePriorities *prometheus.GaugeVec
+}
+
+// NewCollector returns a new capacity collector for the load balancers and the security group of az.
+func NewCollector(az *azureprovider.Cloud, refreshPeriod time.Duration) *Collector {
+	c := &Collector{
+		resourceGroup:        az.ResourceGroup,
+		securityGroupName:    az.SecurityGroupName,
+		loadBalancerClient:   az.LoadBalancerClient,
+		securityGroupsClient: az.SecurityGroupsClient,
+		refreshPeriod:        refreshPeriod,
+
+		loadBalancerRules: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "cloudprovider_azure_load_balancer_rules",
+				Help: "Number of load balancing rules of each load balancer.",
+			},
+			[]string{"resource_group", "load_balancer"},
+		),
+		loadBalancerRulesLimit: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "cloudprovider_azure_load_balancer_rules_limit",
+				Help: "Maximum number of load balancing rules of a load balancer, set by maximumLoadBalancerRuleCount of the cloud config.",
+			},
+		),
+		loadBalancerFrontendIPs: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "cloudprovider_azure_load_balancer_frontend_ip_configurations",
+				Help: "Number of frontend IP configurations of each load balancer.",
+			},
+			[]string{"resource_group", "load_balancer"},
+		),
+		securityGroupRules: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "cloudprovider_azure_security_group_rules",
+				Help: "Number of security rules of the security group, excluding default rules.",
+			},
+			[]string{"resource_group", "security_group"},
+		),
+		securityGroupAvailablePriorities: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "cloudprovider_azure_security_group_available_priorities",
+				Help: "Number of security rule priorities which are still available for services in the security group.",
+			},
+			[]string{"resource_group", "security_group"},
+		),
+	}
+	c.loadBalancerRulesLimit.Set(float64(az.MaximumLoadBalancerRuleCount))
+	return c
+}
+
+// Describe implements prometheus.Collector.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	c.loadBalancerRules.Describe(ch)
+	c.loadBalancerRulesLimit.Describe(ch)
+	c.loadBalancerFrontendIPs.Describe(ch)
+	c.securityGroupRules.Describe(ch)
+	c.securityGroupAvailablePriorities.Describe(ch)
+}
+
+// Collect implements prometheus.Collector. It reports the values of the last refresh, so that
+// scraping doesn't call Azure APIs.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.loadBalancerRules.Collect(ch)
+	c.loadBalancerRulesLimit.Collect(ch)
+	c.loadBalancerFrontendIPs.Collect(ch)
+	c.securityGroupRules.Collect(ch)
+	c.securityGroupAvailablePriorities.Collect(ch)
+}
+
+// Run refreshes the gauges periodically and blocks until stopCh is closed.
+func (c *Collector) Run(stopCh <-chan struct{}) {
+	klog.Infof("Starting Azure capacity metrics collector")
+	defer klog.Infof("Shutting down Azure capacity metrics collector")
+
+	wait.Until(c.refresh, c.refreshPeriod, stopCh)
+}
+
+// refresh updates the gauges. The gauges of a resource type keep their values if it fails to be listed.
+func (c *Collector) refresh() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := c.refreshLoadBalancers(ctx); err != nil {
+		klog.Errorf("Failed to refresh load balancer capacity metrics: %v", err)
+	}
+	if err := c.refreshSecurityGroup(ctx); err != nil {
+		klog.Errorf("Failed to refresh security group capacity metrics: %v", err)
+	}
+}
+
+func (c *Collector) refreshLoadBalancers(ctx context.Context) error {
+	lbs, err := c.loadBalancerClient.List(ctx, c.resourceGroup)
+	if err != nil {
+		return err
+	}
+
+	// Deleted load balancers are dropped.
+	c.loadBalancerRules.Reset()
+	c.loadBalancerFrontendIPs.Reset()
+	for _, lb := range lbs {
+		if lb.Name == nil {
+			continue
+		}
+		rules, frontendIPs := 0, 0
+		if lb.LoadBalancerPropertiesFormat != nil {
+			if lb.LoadBalancingRules != nil {
+				rules = len(*lb.LoadBalancingRules)
+			}
+			if lb.FrontendIPConfigurations != nil {
+				frontendIPs = len(*lb.FrontendIPConfigurations)
+			}
+		}
+		c.loadBalancerRules.WithLabelValues(c.resourceGroup, *lb.Name).Set(float64(rules))
+		c.loadBalancerFrontendIPs.WithLabelValues(c.resourceGroup, *lb.Name).Set(float64(frontendIPs))
+	}
+	return nil
+}
+
+func (c *Collector) refreshSecurityGroup(ctx context.Context) error {
+	if c.securityGroupName == "" {
+		return nil
+	}
+	nsg, err := c.securityGroupsClient.Get(ctx, c.resourceGroup, c.securityGroupName, "")
+	if err != nil {
+		return err
+	}
+
+	var rules []network.SecurityRule
+	if nsg.SecurityGroupPropertiesFormat != nil && nsg.SecurityRules != nil {
+		rules = *nsg.SecurityRules
+	}
+	c.securityGroupRules.WithLabelValues(c.resourceGroup, c.securityGroupName).Set(float64(len(rules)))
+	c.securityGroupAvailablePriorities.WithLabelValues(c.resourceGroup, c.securityGroupName).Set(float64(getAvailablePriorities(rules)))
+	return nil
+}
+
+// getAvailablePriorities returns the number of priorities in [minimumPriority, maximumPriority)
+// which are not used by rules.
+func getAvailablePriorities(rules []network.SecurityRule) int {
+	used := make(map[int32]bool)
+	for _, rule := range rules {
+		if rule.SecurityRulePropertiesFormat == nil || rule.Priority == nil {
+			continue
+		}
+		if *rule.Priority >= minimumPriority && *rule.Priority < maximumPriority {
+			used[*rule.Priority] = true
+		}
+	}
+	return maximumPriority - minimumPriority - len(used)
+}
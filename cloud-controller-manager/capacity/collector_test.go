@@ -0,0 +1,195 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package capacity
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2017-09-01/network"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	azureprovider "k8s.io/kubernetes/pkg/cloudprovider/providers/azure"
+)
+
+type fakeLoadBalancersClient struct {
+	lbs []network.LoadBalancer
+	err error
+}
+
+func (f *fakeLoadBalancersClient) CreateOrUpdate(ctx context.Context, resourceGroupName string, loadBalancerName string, parameters network.LoadBalancer) (*http.Response, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+func (f *fakeLoadBalancersClient) Delete(ctx context.Context, resourceGroupName string, loadBalancerName string) (*http.Response, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+func (f *fakeLoadBalancersClient) Get(ctx context.Context, resourceGroupName string, loadBalancerName string, expand string) (network.LoadBalancer, error) {
+	return network.LoadBalancer{}, fmt.Errorf("not implemented")
+}
+
+func (f *fakeLoadBalancersClient) List(ctx context.Context, resourceGroupName string) ([]network.LoadBalancer, error) {
+	return f.lbs, f.err
+}
+
+type fakeSecurityGroupsClient struct {
+	nsgs map[string]network.SecurityGroup
+}
+
+func (f *fakeSecurityGroupsClient) CreateOrUpdate(ctx context.Context, resourceGroupName string, networkSecurityGroupName string, parameters network.SecurityGroup) (*http.Response, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+func (f *fakeSecurityGroupsClient) Delete(ctx context.Context, resourceGroupName string, networkSecurityGroupName string) (*http.Response, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+func (f *fakeSecurityGroupsClient) Get(ctx context.Context, resourceGroupName string, networkSecurityGroupName string, expand string) (network.SecurityGroup, error) {
+	if nsg, ok := f.nsgs[resourceGroupName+"/"+networkSecurityGroupName]; ok {
+		return nsg, nil
+	}
+	return network.SecurityGroup{}, fmt.Errorf("security group %s not found", networkSecurityGroupName)
+}
+
+func (f *fakeSecurityGroupsClient) List(ctx context.Context, resourceGroupName string) ([]network.SecurityGroup, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+func newLoadBalancer(name string, rules, frontendIPs int) network.LoadBalancer {
+	lbRules := make([]network.LoadBalancingRule, rules)
+	frontendIPConfigurations := make([]network.FrontendIPConfiguration, frontendIPs)
+	return network.LoadBalancer{
+		Name: to.StringPtr(name),
+		LoadBalancerPropertiesFormat: &network.LoadBalancerPropertiesFormat{
+			LoadBalancingRules:       &lbRules,
+			FrontendIPConfigurations: &frontendIPConfigurations,
+		},
+	}
+}
+
+func newSecurityRule(priority int32) network.SecurityRule {
+	return network.SecurityRule{
+		SecurityRulePropertiesFormat: &network.SecurityRulePropertiesFormat{
+			Priority: to.Int32Ptr(priority),
+		},
+	}
+}
+
+func newTestCollector(lbClient *fakeLoadBalancersClient, nsgClient *fakeSecurityGroupsClient) *Collector {
+	az := &azureprovider.Cloud{}
+	az.ResourceGroup = "rg"
+	az.SecurityGroupName = "nsg"
+	az.MaximumLoadBalancerRuleCount = 250
+	az.LoadBalancerClient = lbClient
+	az.SecurityGroupsClient = nsgClient
+	return NewCollector(az, 0)
+}
+
+func TestRefresh(t *testing.T) {
+	rules := []network.SecurityRule{newSecurityRule(100), newSecurityRule(500), newSecurityRule(501), newSecurityRule(501), newSecurityRule(4096)}
+	lbClient := &fakeLoadBalancersClient{
+		lbs: []network.LoadBalancer{
+			newLoadBalancer("kubernetes", 3, 2),
+			newLoadBalancer("kubernetes-internal", 0, 1),
+			{Name: to.StringPtr("empty")},
+		},
+	}
+	nsgClient := &fakeSecurityGroupsClient{
+		nsgs: map[string]network.SecurityGroup{
+			"rg/nsg": {SecurityGroupPropertiesFormat: &network.SecurityGroupPropertiesFormat{SecurityRules: &rules}},
+		},
+	}
+	c := newTestCollector(lbClient, nsgClient)
+	c.refresh()
+
+	expected := `
+# HELP cloudprovider_azure_load_balancer_frontend_ip_configurations Number of frontend IP configurations of each load balancer.
+# TYPE cloudprovider_azure_load_balancer_frontend_ip_configurations gauge
+cloudprovider_azure_load_balancer_frontend_ip_configurations{load_balancer="empty",resource_group="rg"} 0
+cloudprovider_azure_load_balancer_frontend_ip_configurations{load_balancer="kubernetes",resource_group="rg"} 2
+cloudprovider_azure_load_balancer_frontend_ip_configurations{load_balancer="kubernetes-internal",resource_group="rg"} 1
+# HELP cloudprovider_azure_load_balancer_rules Number of load balancing rules of each load balancer.
+# TYPE cloudprovider_azure_load_balancer_rules gauge
+cloudprovider_azure_load_balancer_rules{load_balancer="empty",resource_group="rg"} 0
+cloudprovider_azure_load_balancer_rules{load_balancer="kubernetes",resource_group="rg"} 3
+cloudprovider_azure_load_balancer_rules{load_balancer="kubernetes-internal",resource_group="rg"} 0
+# HELP cloudprovider_azure_load_balancer_rules_limit Maximum number of load balancing rules of a load balancer, set by maximumLoadBalancerRuleCount of the cloud config.
+# TYPE cloudprovider_azure_load_balancer_rules_limit gauge
+cloudprovider_azure_load_balancer_rules_limit 250
+# HELP cloudprovider_azure_security_group_available_priorities Number of security rule priorities which are still available for services in the security group.
+# TYPE cloudprovider_azure_security_group_available_priorities gauge
+cloudprovider_azure_security_group_available_priorities{resource_group="rg",security_group="nsg"} 3594
+# HELP cloudprovider_azure_security_group_rules Number of security rules of the security group, excluding default rules.
+# TYPE cloudprovider_azure_security_group_rules gauge
+cloudprovider_azure_security_group_rules{resource_group="rg",security_group="nsg"} 5
+`
+	assert.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(expected)))
+
+	// Deleted load balancers are dropped.
+	lbClient.lbs = lbClient.lbs[:1]
+	c.refresh()
+	expected = `
+# HELP cloudprovider_azure_load_balancer_rules Number of load balancing rules of each load balancer.
+# TYPE cloudprovider_azure_load_balancer_rules gauge
+cloudprovider_azure_load_balancer_rules{load_balancer="kubernetes",resource_group="rg"} 3
+`
+	assert.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(expected), "cloudprovider_azure_load_balancer_rules"))
+
+	// The last values are kept when listing fails.
+	lbClient.err = fmt.Errorf("throttled")
+	delete(nsgClient.nsgs, "rg/nsg")
+	c.refresh()
+	assert.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(expected), "cloudprovider_azure_load_balancer_rules"))
+	assert.Equal(t, float64(5), testutil.ToFloat64(c.securityGroupRules.WithLabelValues("rg", "nsg")))
+}
+
+func TestGetAvailablePriorities(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		rules    []network.SecurityRule
+		expected int
+	}{
+		{
+			desc:     "all priorities should be available without rules",
+			expected: 3596,
+		},
+		{
+			desc:     "priorities out of the range of services should be ignored",
+			rules:    []network.SecurityRule{newSecurityRule(100), newSecurityRule(499), newSecurityRule(4096)},
+			expected: 3596,
+		},
+		{
+			desc:     "duplicated priorities should be counted once",
+			rules:    []network.SecurityRule{newSecurityRule(500), newSecurityRule(500), newSecurityRule(4095)},
+			expected: 3594,
+		},
+		{
+			desc:     "rules without priority should be ignored",
+			rules:    []network.SecurityRule{{}, {SecurityRulePropertiesFormat: &network.SecurityRulePropertiesFormat{}}},
+			expected: 3596,
+		},
+	}
+
+	for _, test := range testCases {
+		assert.Equal(t, test.expected, getAvailablePriorities(test.rules), test.desc)
+	}
+}
@@ -0,0 +1,122 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+
+	"github.com/spf13/pflag"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/cloud-provider-azure/cloud-controller-manager/cloudconfig"
+	"k8s.io/klog"
+)
+
+// sharedMemoryDir is a memory-backed file system on Linux.
+const sharedMemoryDir = "/dev/shm"
+
+// cloudConfigFiles holds the files written by prepareCloudConfig. The merged config holds
+// secrets, hence the files are written to memory-backed storage when available, only readable
+// by the owner. The Azure cloud provider reads them once while it is created, hence they are
+// removed right after by the azureCloud factory and don't outlive the start of the cloud
+// controller manager.
+type cloudConfigFiles struct {
+	paths []string
+}
+
+// getDir returns the directory the files are written to, preferring memory-backed storage.
+func (f *cloudConfigFiles) getDir() string {
+	if info, err := os.Stat(sharedMemoryDir); err == nil && info.IsDir() {
+		return sharedMemoryDir
+	}
+	klog.Warningf("%s is not available, writing cloud config files to %s", sharedMemoryDir, os.TempDir())
+	return os.TempDir()
+}
+
+// add adds path to the files which are removed by Remove.
+func (f *cloudConfigFiles) add(path string) {
+	f.paths = append(f.paths, path)
+}
+
+// write writes data to a new file in dir, which is removed by Remove.
+func (f *cloudConfigFiles) write(dir, prefix string, data []byte) (string, error) {
+	file, err := ioutil.TempFile(dir, prefix)
+	if err != nil {
+		return "", err
+	}
+	f.add(file.Name())
+	defer file.Close()
+	if _, err := file.Write(data); err != nil {
+		return "", err
+	}
+	return file.Name(), nil
+}
+
+// Remove removes the files. It is called once the Azure cloud provider has read them.
+func (f *cloudConfigFiles) Remove() {
+	for _, path := range f.paths {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			klog.Warningf("Failed to remove cloud config file %q: %v", path, err)
+		}
+	}
+	f.paths = nil
+}
+
+// prepareCloudConfig loads the cloud config from the Secret, the --cloud-config file and the
+// overrides. If the config is not only loaded from the file or has a custom cloud environment,
+// --cloud-config is pointed to the merged config, which is then read by the Azure cloud provider.
+// The written files are added to files.
+func prepareCloudConfig(o *cloudconfig.Options, fs *pflag.FlagSet, files *cloudConfigFiles) error {
+	getSecret := func(namespace, name string) (*v1.Secret, error) {
+		kubeClient, err := newKubeClient(fs, "azure-cloud-config-loader")
+		if err != nil {
+			return nil, err
+		}
+		return kubeClient.CoreV1().Secrets(namespace).Get(name, metav1.GetOptions{})
+	}
+	loaded, err := cloudconfig.Load(o, getFlagValue(fs, "cloud-config"), getSecret)
+	if err != nil || loaded == nil {
+		return err
+	}
+	for _, warning := range loaded.Warnings {
+		klog.Warning(warning)
+	}
+
+	dir := files.getDir()
+	environmentFile, err := cloudconfig.ApplyEnvironment(loaded, dir)
+	if err != nil {
+		return err
+	}
+	if environmentFile != "" {
+		klog.Infof("Using custom cloud environment from %q", environmentFile)
+		if loaded.Environment.CloudEnvironmentFilePath == "" {
+			files.add(environmentFile)
+		}
+	}
+	if !loaded.HasSourceOtherThan(cloudconfig.SourceFile) {
+		return nil
+	}
+	klog.Infof("Loaded cloud config, fields set by %s", loaded)
+
+	path, err := files.write(dir, "azure-cloud-config", loaded.Data)
+	if err != nil {
+		return err
+	}
+	return fs.Set("cloud-config", path)
+}
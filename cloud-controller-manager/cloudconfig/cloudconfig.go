@@ -0,0 +1,312 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package cloudconfig loads the Azure cloud config from several sources, e.g. the
+// file passed by --cloud-config, a Kubernetes Secret, environment variables and the
+// --cloud-config-override flag, and records which source set each field.
+package cloudconfig
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/spf13/pflag"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/klog"
+	azureprovider "k8s.io/kubernetes/pkg/cloudprovider/providers/azure"
+	"sigs.k8s.io/yaml"
+)
+
+// Source is the source of cloud config fields.
+type Source string
+
+const (
+	// SourceFile is the file passed by --cloud-config.
+	SourceFile Source = "file"
+	// SourceSecret is the Kubernetes Secret set by --cloud-config-secret-name.
+	SourceSecret Source = "secret"
+)
+
+// FileMode is how the cloud config file is combined with the Secret.
+type FileMode string
+
+const (
+	// FileModeFallback uses the file for fields which are not set in the Secret.
+	FileModeFallback FileMode = "fallback"
+	// FileModeOverlay uses the file for fields which are set in it, overriding the Secret.
+	FileModeOverlay FileMode = "overlay"
+)
+
+// Options holds the options for loading the cloud config.
+type Options struct {
+	SecretNamespace string
+	SecretName      string
+	SecretKey       string
+	FileMode        FileMode
+	// EnvOverrides enables overriding fields by environment variables, e.g. AZURE_LOAD_BALANCER_SKU.
+	EnvOverrides bool
+	// Overrides are fields in "field=value" format, overriding all other sources.
+	Overrides []string
+}
+
+// NewOptions returns the default options, with which the cloud config is only loaded from the file.
+func NewOptions() *Options {
+	return &Options{
+		SecretNamespace: "kube-system",
+		SecretKey:       "cloud-config",
+		FileMode:        FileModeFallback,
+	}
+}
+
+// AddFlags adds flags for loading the cloud config to the specified FlagSet.
+func (o *Options) AddFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&o.SecretName, "cloud-config-secret-name", o.SecretName,
+		"Name of the Secret holding the cloud config. If not set, the cloud config is only loaded from --cloud-config.")
+	fs.StringVar(&o.SecretNamespace, "cloud-config-secret-namespace", o.SecretNamespace, "Namespace of the Secret holding the cloud config.")
+	fs.StringVar(&o.SecretKey, "cloud-config-secret-key", o.SecretKey, "Key of the cloud config in the Secret.")
+	fs.StringVar((*string)(&o.FileMode), "cloud-config-file-mode", string(o.FileMode),
+		"How the --cloud-config file is combined with the Secret: 'fallback' uses the file for fields not set in the Secret, 'overlay' uses the file for fields set in it.")
+	fs.BoolVar(&o.EnvOverrides, "cloud-config-env-overrides", o.EnvOverrides,
+		"Override cloud config fields by environment variables named after them, e.g. AZURE_LOAD_BALANCER_SKU for loadBalancerSku.")
+	fs.StringArrayVar(&o.Overrides, "cloud-config-override", o.Overrides,
+		"Cloud config field to override in field=value format, e.g. loadBalancerSku=standard. Could be repeated, and takes precedence over all other sources.")
+}
+
+// Validate checks the options.
+func (o *Options) Validate() error {
+	if o.FileMode != FileModeFallback && o.FileMode != FileModeOverlay {
+		return fmt.Errorf("invalid --cloud-config-file-mode %q, must be %q or %q", o.FileMode, FileModeFallback, FileModeOverlay)
+	}
+	if o.SecretName != "" && (o.SecretNamespace == "" || o.SecretKey == "") {
+		return fmt.Errorf("--cloud-config-secret-namespace and --cloud-config-secret-key must be set with --cloud-config-secret-name")
+	}
+	return nil
+}
+
+// SecretGetter gets the Secret in the namespace.
+type SecretGetter func(namespace, name string) (*v1.Secret, error)
+
+// Layer is the cloud config from one source. Fields set in later layers override earlier ones.
+type Layer struct {
+	Source Source
+	Data   []byte
+}
+
+// LoadedConfig is the cloud config merged from all sources.
+type LoadedConfig struct {
+	Config *azureprovider.Config
+	// Environment holds the custom cloud environment, which is not part of Config.
+	Environment *EnvironmentConfig
+	// Data is the merged cloud config in JSON, which could be parsed by the Azure cloud provider.
+	Data []byte
+	// Sources maps the JSON names of the fields to the sources which set them.
+	Sources map[string]Source
+	// Warnings holds the deprecations and ignored fields found in the layers, which should be logged.
+	Warnings []string
+	// UnknownFields holds the unknown fields of the unversioned layers, which are ignored.
+	UnknownFields []string
+
+	fields map[string]interface{}
+}
+
+// Load loads the cloud config from the file and, if configured, the Secret, and then
+// applies the environment variable and flag overrides. It returns nil if none of
+// them is configured.
+func Load(o *Options, filePath string, getSecret SecretGetter) (*LoadedConfig, error) {
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+
+	var overrideLayers []Layer
+	if o.EnvOverrides {
+		envLayer, err := GetEnvLayer(os.LookupEnv)
+		if err != nil {
+			return nil, err
+		}
+		if envLayer != nil {
+			overrideLayers = append(overrideLayers, *envLayer)
+		}
+	}
+	flagLayer, err := GetFlagLayer(o.Overrides)
+	if err != nil {
+		return nil, err
+	}
+	if flagLayer != nil {
+		overrideLayers = append(overrideLayers, *flagLayer)
+	}
+
+	var fileLayer, secretLayer *Layer
+	if filePath != "" {
+		data, err := ioutil.ReadFile(filePath)
+		if err != nil && !(os.IsNotExist(err) && o.SecretName != "") {
+			return nil, fmt.Errorf("reading cloud config file %q: %v", filePath, err)
+		}
+		if err == nil {
+			fileLayer = &Layer{Source: SourceFile, Data: data}
+		}
+	}
+
+	if o.SecretName != "" {
+		data, err := getSecretData(o, getSecret)
+		if err != nil {
+			return nil, err
+		}
+		if data != nil {
+			secretLayer = &Layer{Source: SourceSecret, Data: data}
+		} else if fileLayer != nil {
+			klog.Warningf("Cloud config Secret %s/%s not found, using cloud config file %q only", o.SecretNamespace, o.SecretName, filePath)
+		}
+	}
+
+	var layers []Layer
+	switch {
+	case secretLayer == nil && fileLayer == nil:
+		if o.SecretName != "" {
+			return nil, fmt.Errorf("Secret %s/%s and cloud config file %q are both missing", o.SecretNamespace, o.SecretName, filePath)
+		}
+		if len(overrideLayers) == 0 {
+			return nil, nil
+		}
+	case secretLayer == nil:
+		layers = []Layer{*fileLayer}
+	case fileLayer == nil:
+		layers = []Layer{*secretLayer}
+	case o.FileMode == FileModeOverlay:
+		layers = []Layer{*secretLayer, *fileLayer}
+	default:
+		layers = []Layer{*fileLayer, *secretLayer}
+	}
+
+	return Merge(append(layers, overrideLayers...)...)
+}
+
+// getSecretData returns the cloud config in the Secret, or nil if the Secret doesn't exist.
+func getSecretData(o *Options, getSecret SecretGetter) ([]byte, error) {
+	secret, err := getSecret(o.SecretNamespace, o.SecretName)
+	if errors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("getting cloud config Secret %s/%s: %v", o.SecretNamespace, o.SecretName, err)
+	}
+
+	data, ok := secret.Data[o.SecretKey]
+	if !ok {
+		return nil, fmt.Errorf("key %q not found in cloud config Secret %s/%s", o.SecretKey, o.SecretNamespace, o.SecretName)
+	}
+	return data, nil
+}
+
+// Merge merges the layers field by field, with fields set in later layers overriding
+// earlier ones. Layers are decoded by their apiVersion, see ConfigV1. Field names of
+// unversioned layers are matched case-insensitively.
+func Merge(layers ...Layer) (*LoadedConfig, error) {
+	c := &LoadedConfig{
+		Sources: make(map[string]Source),
+		fields:  make(map[string]interface{}),
+	}
+	for _, layer := range layers {
+		fields, unknown, warnings, err := decodeLayer(layer)
+		if err != nil {
+			return nil, err
+		}
+		c.UnknownFields = append(c.UnknownFields, unknown...)
+		c.Warnings = append(c.Warnings, warnings...)
+
+		for name, value := range fields {
+			c.fields[name] = value
+			c.Sources[name] = layer.Source
+		}
+	}
+
+	if err := c.update(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Set sets the field to value, recording the source which set it.
+func (c *LoadedConfig) Set(name string, value interface{}, source Source) error {
+	name = canonicalFieldName(name)
+	c.fields[name] = value
+	c.Sources[name] = source
+	return c.update()
+}
+
+// update encodes the merged fields and decodes them into Config and Environment.
+func (c *LoadedConfig) update() error {
+	data, err := json.Marshal(c.fields)
+	if err != nil {
+		return err
+	}
+	config := azureprovider.Config{}
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return fmt.Errorf("parsing merged cloud config: %v", err)
+	}
+	environment := EnvironmentConfig{}
+	if err := yaml.Unmarshal(data, &environment); err != nil {
+		return fmt.Errorf("parsing cloud environment of merged cloud config: %v", err)
+	}
+
+	c.Config = &config
+	c.Environment = &environment
+	c.Data = data
+	return nil
+}
+
+// FieldsBySource returns the sorted field names set by each source, e.g. for logging.
+func (c *LoadedConfig) FieldsBySource() map[Source][]string {
+	fields := make(map[Source][]string)
+	for name, source := range c.Sources {
+		fields[source] = append(fields[source], name)
+	}
+	for _, names := range fields {
+		sort.Strings(names)
+	}
+	return fields
+}
+
+// String returns the fields set by each source, e.g. "file: [location]; secret: [aadClientId aadClientSecret]".
+func (c *LoadedConfig) String() string {
+	fields := c.FieldsBySource()
+	var sources []string
+	for source := range fields {
+		sources = append(sources, string(source))
+	}
+	sort.Strings(sources)
+
+	var parts []string
+	for _, source := range sources {
+		parts = append(parts, fmt.Sprintf("%s: %v", source, fields[Source(source)]))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// HasSourceOtherThan returns whether any field is set by a source other than the specified one.
+func (c *LoadedConfig) HasSourceOtherThan(source Source) bool {
+	for _, s := range c.Sources {
+		if s != source {
+			return true
+		}
+	}
+	return false
+}
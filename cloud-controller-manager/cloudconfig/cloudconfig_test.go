@@ -0,0 +1,207 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cloudconfig
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	testFileConfig = `{
+    "cloud": "AzurePublicCloud",
+    "resourceGroup": "file-rg",
+    "location": "westus2",
+    "aadClientId": "file-client"
+}`
+	testSecretConfig = `
+aadClientId: secret-client
+aadclientsecret: secret
+resourceGroup: secret-rg
+`
+)
+
+func TestMerge(t *testing.T) {
+	loaded, err := Merge(
+		Layer{Source: SourceFile, Data: []byte(testFileConfig)},
+		Layer{Source: SourceSecret, Data: []byte(testSecretConfig)},
+	)
+	assert.NoError(t, err)
+
+	assert.Equal(t, "AzurePublicCloud", loaded.Config.Cloud)
+	assert.Equal(t, "westus2", loaded.Config.Location)
+	assert.Equal(t, "secret-rg", loaded.Config.ResourceGroup)
+	assert.Equal(t, "secret-client", loaded.Config.AADClientID)
+	assert.Equal(t, "secret", loaded.Config.AADClientSecret)
+	assert.Equal(t, map[string]Source{
+		"cloud":           SourceFile,
+		"location":        SourceFile,
+		"resourceGroup":   SourceSecret,
+		"aadClientId":     SourceSecret,
+		"aadClientSecret": SourceSecret,
+	}, loaded.Sources)
+	assert.Equal(t, "file: [cloud location]; secret: [aadClientId aadClientSecret resourceGroup]", loaded.String())
+}
+
+func TestMergeInvalid(t *testing.T) {
+	_, err := Merge(Layer{Source: SourceSecret, Data: []byte("{")})
+	assert.Error(t, err)
+}
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cloudconfig")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	filePath := filepath.Join(dir, "azure.json")
+	assert.NoError(t, ioutil.WriteFile(filePath, []byte(testFileConfig), 0600))
+
+	secret := &v1.Secret{Data: map[string][]byte{"cloud-config": []byte(testSecretConfig)}}
+	getSecret := func(namespace, name string) (*v1.Secret, error) {
+		if namespace == "kube-system" && name == "azure-cloud-config" {
+			return secret, nil
+		}
+		return nil, errors.NewNotFound(schema.GroupResource{Resource: "secrets"}, name)
+	}
+	failingGetSecret := func(namespace, name string) (*v1.Secret, error) {
+		return nil, fmt.Errorf("forbidden")
+	}
+
+	testCases := []struct {
+		desc               string
+		secretName         string
+		secretKey          string
+		fileMode           FileMode
+		filePath           string
+		getSecret          SecretGetter
+		expectedNil        bool
+		expectedClientID   string
+		expectedClientFrom Source
+		expectErr          bool
+	}{
+		{
+			desc:        "nil should be returned if neither file nor Secret is configured",
+			getSecret:   getSecret,
+			expectedNil: true,
+		},
+		{
+			desc:               "file should be loaded if Secret is not configured",
+			filePath:           filePath,
+			getSecret:          getSecret,
+			expectedClientID:   "file-client",
+			expectedClientFrom: SourceFile,
+		},
+		{
+			desc:               "Secret should override file in fallback mode",
+			secretName:         "azure-cloud-config",
+			filePath:           filePath,
+			getSecret:          getSecret,
+			expectedClientID:   "secret-client",
+			expectedClientFrom: SourceSecret,
+		},
+		{
+			desc:               "file should override Secret in overlay mode",
+			secretName:         "azure-cloud-config",
+			fileMode:           FileModeOverlay,
+			filePath:           filePath,
+			getSecret:          getSecret,
+			expectedClientID:   "file-client",
+			expectedClientFrom: SourceFile,
+		},
+		{
+			desc:               "Secret should be loaded if file doesn't exist",
+			secretName:         "azure-cloud-config",
+			filePath:           filepath.Join(dir, "missing.json"),
+			getSecret:          getSecret,
+			expectedClientID:   "secret-client",
+			expectedClientFrom: SourceSecret,
+		},
+		{
+			desc:               "file should be loaded if Secret doesn't exist",
+			secretName:         "missing",
+			filePath:           filePath,
+			getSecret:          getSecret,
+			expectedClientID:   "file-client",
+			expectedClientFrom: SourceFile,
+		},
+		{
+			desc:       "error should be returned if both Secret and file are missing",
+			secretName: "missing",
+			filePath:   filepath.Join(dir, "missing.json"),
+			getSecret:  getSecret,
+			expectErr:  true,
+		},
+		{
+			desc:      "error should be returned if file doesn't exist without Secret",
+			filePath:  filepath.Join(dir, "missing.json"),
+			getSecret: getSecret,
+			expectErr: true,
+		},
+		{
+			desc:       "error should be returned if Secret can't be got",
+			secretName: "azure-cloud-config",
+			filePath:   filePath,
+			getSecret:  failingGetSecret,
+			expectErr:  true,
+		},
+		{
+			desc:       "error should be returned if key is not in Secret",
+			secretName: "azure-cloud-config",
+			secretKey:  "azure.json",
+			getSecret:  getSecret,
+			expectErr:  true,
+		},
+		{
+			desc:      "error should be returned for invalid file mode",
+			fileMode:  "merge",
+			getSecret: getSecret,
+			expectErr: true,
+		},
+	}
+
+	for _, test := range testCases {
+		o := NewOptions()
+		o.SecretName = test.secretName
+		if test.secretKey != "" {
+			o.SecretKey = test.secretKey
+		}
+		if test.fileMode != "" {
+			o.FileMode = test.fileMode
+		}
+
+		loaded, err := Load(o, test.filePath, test.getSecret)
+		if test.expectErr {
+			assert.Error(t, err, test.desc)
+			continue
+		}
+		assert.NoError(t, err, test.desc)
+		if test.expectedNil {
+			assert.Nil(t, loaded, test.desc)
+			continue
+		}
+		assert.Equal(t, test.expectedClientID, loaded.Config.AADClientID, test.desc)
+		assert.Equal(t, test.expectedClientFrom, loaded.Sources["aadClientId"], test.desc)
+	}
+}
@@ -0,0 +1,150 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cloudconfig
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/Azure/go-autorest/autorest/azure"
+
+	"k8s.io/kubernetes/pkg/cloudprovider/providers/azure/auth"
+)
+
+const (
+	// azureStackCloudName is the only cloud name for which go-autorest loads the
+	// environment from the file set by the AZURE_ENVIRONMENT_FILEPATH env variable.
+	azureStackCloudName = "AzureStackCloud"
+
+	// SourceEnvironment marks the fields set for the custom cloud environment.
+	SourceEnvironment Source = "cloud-environment"
+)
+
+// EnvironmentConfig holds the custom cloud environment (e.g. Azure Stack Hub or a
+// sovereign cloud), given either as a path to an environment JSON or as inline endpoints.
+type EnvironmentConfig struct {
+	// CloudEnvironmentFilePath is the path to an environment JSON in go-autorest format.
+	CloudEnvironmentFilePath string `json:"cloudEnvironmentFilePath,omitempty" yaml:"cloudEnvironmentFilePath,omitempty"`
+	// CloudEnvironment holds the inline endpoints of the environment.
+	CloudEnvironment *azure.Environment `json:"cloudEnvironment,omitempty" yaml:"cloudEnvironment,omitempty"`
+}
+
+// IsCustom returns true if a custom cloud environment is configured.
+func (e *EnvironmentConfig) IsCustom() bool {
+	return e.CloudEnvironmentFilePath != "" || e.CloudEnvironment != nil
+}
+
+// Validate checks that only one of the environment file and the inline environment is
+// set, and that the environment has the endpoints used by the Azure cloud provider.
+func (e *EnvironmentConfig) Validate() error {
+	if e.CloudEnvironmentFilePath != "" && e.CloudEnvironment != nil {
+		return fmt.Errorf("only one of cloudEnvironmentFilePath and cloudEnvironment could be set")
+	}
+
+	if e.CloudEnvironmentFilePath != "" {
+		env, err := azure.EnvironmentFromFile(e.CloudEnvironmentFilePath)
+		if err != nil {
+			return fmt.Errorf("loading cloud environment from %q: %v", e.CloudEnvironmentFilePath, err)
+		}
+		return validateEnvironment(&env)
+	}
+	if e.CloudEnvironment != nil {
+		return validateEnvironment(e.CloudEnvironment)
+	}
+	return nil
+}
+
+// GetEnvironment returns the custom cloud environment if it is configured, or the
+// built-in environment of the cloud name otherwise.
+func (c *LoadedConfig) GetEnvironment() (*azure.Environment, error) {
+	if !c.Environment.IsCustom() {
+		return auth.ParseAzureEnvironment(c.Config.Cloud)
+	}
+	if err := c.Environment.Validate(); err != nil {
+		return nil, err
+	}
+	if c.Environment.CloudEnvironment != nil {
+		return c.Environment.CloudEnvironment, nil
+	}
+	env, err := azure.EnvironmentFromFile(c.Environment.CloudEnvironmentFilePath)
+	return &env, err
+}
+
+// validateEnvironment checks the endpoints used by the Azure cloud provider.
+func validateEnvironment(env *azure.Environment) error {
+	var missing []string
+	for name, value := range map[string]string{
+		"name":                       env.Name,
+		"resourceManagerEndpoint":    env.ResourceManagerEndpoint,
+		"activeDirectoryEndpoint":    env.ActiveDirectoryEndpoint,
+		"serviceManagementEndpoint":  env.ServiceManagementEndpoint,
+		"storageEndpointSuffix":      env.StorageEndpointSuffix,
+		"resourceManagerVMDNSSuffix": env.ResourceManagerVMDNSSuffix,
+	} {
+		if value == "" {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return fmt.Errorf("cloud environment %q is missing %s", env.Name, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// ApplyEnvironment makes the Azure cloud provider use the custom cloud environment of
+// the config, if any. The provider only accepts built-in cloud names, but go-autorest
+// loads the endpoints of AzureStackCloud from the file set by AZURE_ENVIRONMENT_FILEPATH,
+// hence inline endpoints are written to a file in dir, the env variable is set and the
+// cloud name is set to AzureStackCloud. It returns the path of the environment file.
+func ApplyEnvironment(c *LoadedConfig, dir string) (string, error) {
+	if !c.Environment.IsCustom() {
+		return "", nil
+	}
+	if err := c.Environment.Validate(); err != nil {
+		return "", err
+	}
+
+	path := c.Environment.CloudEnvironmentFilePath
+	if path == "" {
+		data, err := json.Marshal(c.Environment.CloudEnvironment)
+		if err != nil {
+			return "", err
+		}
+		f, err := ioutil.TempFile(dir, "azure-cloud-environment")
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+		if _, err := f.Write(data); err != nil {
+			return "", err
+		}
+		path = f.Name()
+	}
+
+	if err := os.Setenv(azure.EnvironmentFilepathName, path); err != nil {
+		return "", err
+	}
+	if err := c.Set("cloud", azureStackCloudName, SourceEnvironment); err != nil {
+		return "", err
+	}
+	return path, nil
+}
@@ -0,0 +1,119 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cloudconfig
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Azure/go-autorest/autorest/azure"
+	"github.com/stretchr/testify/assert"
+)
+
+const testEnvironment = `{
+    "name": "AzureStackHub",
+    "resourceManagerEndpoint": "https://management.local.azurestack.external/",
+    "activeDirectoryEndpoint": "https://login.microsoftonline.com/",
+    "serviceManagementEndpoint": "https://management.azurestackhub.onmicrosoft.com/0a1b2c3d",
+    "storageEndpointSuffix": "local.azurestack.external",
+    "resourceManagerVMDNSSuffix": "cloudapp.azurestack.external"
+}`
+
+func TestApplyEnvironment(t *testing.T) {
+	defer os.Setenv(azure.EnvironmentFilepathName, os.Getenv(azure.EnvironmentFilepathName))
+
+	dir, err := ioutil.TempDir("", "cloudconfig")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	environmentFile := filepath.Join(dir, "environment.json")
+	assert.NoError(t, ioutil.WriteFile(environmentFile, []byte(testEnvironment), 0600))
+	incompleteFile := filepath.Join(dir, "incomplete.json")
+	assert.NoError(t, ioutil.WriteFile(incompleteFile, []byte(`{"name": "AzureStackHub"}`), 0600))
+
+	testCases := []struct {
+		desc          string
+		config        string
+		expectCustom  bool
+		expectedCloud string
+		expectErr     bool
+	}{
+		{
+			desc:          "built-in cloud should be kept",
+			config:        `{"cloud": "AzureChinaCloud"}`,
+			expectedCloud: "AzureChinaCloud",
+		},
+		{
+			desc:          "environment file should be used",
+			config:        `{"cloud": "AzurePublicCloud", "cloudEnvironmentFilePath": "` + environmentFile + `"}`,
+			expectCustom:  true,
+			expectedCloud: azureStackCloudName,
+		},
+		{
+			desc:          "inline environment should be used",
+			config:        `{"cloudenvironment": ` + testEnvironment + `}`,
+			expectCustom:  true,
+			expectedCloud: azureStackCloudName,
+		},
+		{
+			desc:      "error should be returned for missing environment file",
+			config:    `{"cloudEnvironmentFilePath": "` + filepath.Join(dir, "missing.json") + `"}`,
+			expectErr: true,
+		},
+		{
+			desc:      "error should be returned for incomplete environment",
+			config:    `{"cloudEnvironmentFilePath": "` + incompleteFile + `"}`,
+			expectErr: true,
+		},
+		{
+			desc:      "error should be returned if both environment file and inline environment are set",
+			config:    `{"cloudEnvironmentFilePath": "` + environmentFile + `", "cloudEnvironment": ` + testEnvironment + `}`,
+			expectErr: true,
+		},
+	}
+
+	for _, test := range testCases {
+		os.Unsetenv(azure.EnvironmentFilepathName)
+		loaded, err := Merge(Layer{Source: SourceFile, Data: []byte(test.config)})
+		assert.NoError(t, err, test.desc)
+
+		env, envErr := loaded.GetEnvironment()
+		path, err := ApplyEnvironment(loaded, dir)
+		if test.expectErr {
+			assert.Error(t, err, test.desc)
+			assert.Error(t, envErr, test.desc)
+			continue
+		}
+		assert.NoError(t, err, test.desc)
+		assert.NoError(t, envErr, test.desc)
+		assert.Equal(t, test.expectedCloud, loaded.Config.Cloud, test.desc)
+		if !test.expectCustom {
+			assert.Empty(t, path, test.desc)
+			assert.Equal(t, azure.ChinaCloud.ResourceManagerEndpoint, env.ResourceManagerEndpoint, test.desc)
+			continue
+		}
+		assert.Equal(t, "https://management.local.azurestack.external/", env.ResourceManagerEndpoint, test.desc)
+
+		assert.Equal(t, SourceEnvironment, loaded.Sources["cloud"], test.desc)
+		assert.Equal(t, path, os.Getenv(azure.EnvironmentFilepathName), test.desc)
+		// The Azure cloud provider resolves the environment by the cloud name.
+		providerEnv, err := azure.EnvironmentFromName(loaded.Config.Cloud)
+		assert.NoError(t, err, test.desc)
+		assert.Equal(t, env.ResourceManagerEndpoint, providerEnv.ResourceManagerEndpoint, test.desc)
+	}
+}
@@ -0,0 +1,66 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cloudconfig
+
+import (
+	"reflect"
+	"strings"
+
+	azureprovider "k8s.io/kubernetes/pkg/cloudprovider/providers/azure"
+)
+
+// fieldNames maps the lower-cased JSON names of Config fields (including the embedded
+// AzureAuthConfig) and EnvironmentConfig fields to their canonical names, e.g. "aadclientid"
+// to "aadClientId".
+var fieldNames = getFieldNames(reflect.TypeOf(struct {
+	azureprovider.Config
+	EnvironmentConfig
+}{}))
+
+// fieldNamesV1 maps the lower-cased JSON names of ConfigV1 fields to their canonical names.
+var fieldNamesV1 = getFieldNames(reflect.TypeOf(ConfigV1{}))
+
+func getFieldNames(t reflect.Type) map[string]string {
+	names := make(map[string]string)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Anonymous {
+			for k, v := range getFieldNames(field.Type) {
+				names[k] = v
+			}
+			continue
+		}
+
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		names[strings.ToLower(name)] = name
+	}
+
+	return names
+}
+
+// canonicalFieldName returns the canonical JSON name of the Config field. Keys
+// are matched case-insensitively like encoding/json does, and unknown keys are
+// returned unchanged.
+func canonicalFieldName(key string) string {
+	if name, ok := fieldNames[strings.ToLower(key)]; ok {
+		return name
+	}
+	return key
+}
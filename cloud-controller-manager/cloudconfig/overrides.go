@@ -0,0 +1,176 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cloudconfig
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	azureprovider "k8s.io/kubernetes/pkg/cloudprovider/providers/azure"
+)
+
+const (
+	// SourceEnv is the environment variables, e.g. AZURE_LOAD_BALANCER_SKU.
+	SourceEnv Source = "env"
+	// SourceFlag is the --cloud-config-override flag.
+	SourceFlag Source = "flag"
+
+	envPrefix = "AZURE_"
+)
+
+// overridableFields maps the canonical JSON names of the scalar fields of Config and
+// EnvironmentConfig to their types.
+var overridableFields = getOverridableFields(reflect.TypeOf(struct {
+	azureprovider.Config
+	EnvironmentConfig
+}{}))
+
+func getOverridableFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Anonymous {
+			for k, v := range getOverridableFields(field.Type) {
+				fields[k] = v
+			}
+			continue
+		}
+
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		fieldType := field.Type
+		if fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+		switch fieldType.Kind() {
+		case reflect.String, reflect.Bool, reflect.Int, reflect.Float32, reflect.Float64:
+			if name != "" && name != "-" {
+				fields[name] = fieldType
+			}
+		}
+	}
+
+	return fields
+}
+
+// EnvName returns the environment variable of the field, e.g. AZURE_LOAD_BALANCER_SKU
+// for loadBalancerSku and AZURE_CLOUD_PROVIDER_RATE_LIMIT_QPS_WRITE for cloudProviderRateLimitQPSWrite.
+func EnvName(name string) string {
+	runes := []rune(name)
+	var words []string
+	start := 0
+	for i := 1; i < len(runes); i++ {
+		// A word starts at an upper case letter after a lower case letter or a digit,
+		// or at the last upper case letter of an acronym followed by a lower case letter.
+		if unicode.IsUpper(runes[i]) && (!unicode.IsUpper(runes[i-1]) ||
+			(i+1 < len(runes) && unicode.IsLower(runes[i+1]))) {
+			words = append(words, string(runes[start:i]))
+			start = i
+		}
+	}
+	words = append(words, string(runes[start:]))
+
+	return envPrefix + strings.ToUpper(strings.Join(words, "_"))
+}
+
+// EnvNames returns the environment variables of all overridable fields, keyed by field name.
+func EnvNames() map[string]string {
+	names := make(map[string]string)
+	for name := range overridableFields {
+		names[name] = EnvName(name)
+	}
+	return names
+}
+
+// GetEnvLayer returns the fields set by the environment variables, which are looked up by lookupEnv, e.g. os.LookupEnv.
+func GetEnvLayer(lookupEnv func(key string) (string, bool)) (*Layer, error) {
+	values := make(map[string]string)
+	for name := range overridableFields {
+		if value, ok := lookupEnv(EnvName(name)); ok {
+			values[name] = value
+		}
+	}
+	if len(values) == 0 {
+		return nil, nil
+	}
+
+	return newOverrideLayer(SourceEnv, values, func(name string) string { return EnvName(name) })
+}
+
+// GetFlagLayer returns the fields set by the overrides in "field=value" format.
+func GetFlagLayer(overrides []string) (*Layer, error) {
+	values := make(map[string]string)
+	for _, override := range overrides {
+		kv := strings.SplitN(override, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("invalid cloud config override %q, must be in field=value format", override)
+		}
+		values[canonicalFieldName(kv[0])] = kv[1]
+	}
+	if len(values) == 0 {
+		return nil, nil
+	}
+
+	return newOverrideLayer(SourceFlag, values, func(name string) string { return "--cloud-config-override " + name })
+}
+
+// newOverrideLayer parses the string values by the types of the fields.
+func newOverrideLayer(source Source, values map[string]string, describe func(name string) string) (*Layer, error) {
+	var names []string
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fields := make(map[string]interface{})
+	for _, name := range names {
+		fieldType, ok := overridableFields[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown cloud config field %q", name)
+		}
+
+		value, err := parseValue(values[name], fieldType)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value of %s: %v", describe(name), err)
+		}
+		fields[name] = value
+	}
+
+	fields[apiVersionField] = APIVersionV1
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	return &Layer{Source: source, Data: data}, nil
+}
+
+func parseValue(value string, fieldType reflect.Type) (interface{}, error) {
+	switch fieldType.Kind() {
+	case reflect.Bool:
+		return strconv.ParseBool(value)
+	case reflect.Int:
+		return strconv.Atoi(value)
+	case reflect.Float32, reflect.Float64:
+		return strconv.ParseFloat(value, 64)
+	default:
+		return value, nil
+	}
+}
@@ -0,0 +1,181 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cloudconfig
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEnvName(t *testing.T) {
+	testCases := map[string]string{
+		"cloud":                          "AZURE_CLOUD",
+		"loadBalancerSku":                "AZURE_LOAD_BALANCER_SKU",
+		"aadClientId":                    "AZURE_AAD_CLIENT_ID",
+		"userAssignedIdentityID":         "AZURE_USER_ASSIGNED_IDENTITY_ID",
+		"cloudProviderRateLimitQPS":      "AZURE_CLOUD_PROVIDER_RATE_LIMIT_QPS",
+		"cloudProviderRateLimitQPSWrite": "AZURE_CLOUD_PROVIDER_RATE_LIMIT_QPS_WRITE",
+		"excludeMasterFromStandardLB":    "AZURE_EXCLUDE_MASTER_FROM_STANDARD_LB",
+	}
+	for name, expected := range testCases {
+		assert.Equal(t, expected, EnvName(name), name)
+	}
+
+	// Environment variables must be unique, and nested objects can't be overridden.
+	names := make(map[string]string)
+	for field, name := range EnvNames() {
+		assert.NotContains(t, names, name, field)
+		names[name] = field
+	}
+	assert.NotContains(t, EnvNames(), "cloudEnvironment")
+}
+
+func TestOverrideLayers(t *testing.T) {
+	env := map[string]string{
+		"AZURE_LOAD_BALANCER_SKU":                "standard",
+		"AZURE_CLOUD_PROVIDER_RATE_LIMIT_QPS":    "2.5",
+		"AZURE_MAXIMUM_LOAD_BALANCER_RULE_COUNT": "100",
+		"AZURE_EXCLUDE_MASTER_FROM_STANDARD_LB":  "false",
+		"AZURE_UNRELATED":                        "ignored",
+	}
+	lookupEnv := func(key string) (string, bool) {
+		value, ok := env[key]
+		return value, ok
+	}
+	envLayer, err := GetEnvLayer(lookupEnv)
+	assert.NoError(t, err)
+	flagLayer, err := GetFlagLayer([]string{"loadbalancersku=basic", "location=eastus=1"})
+	assert.NoError(t, err)
+
+	loaded, err := Merge(Layer{Source: SourceFile, Data: []byte(testFileConfig)}, *envLayer, *flagLayer)
+	assert.NoError(t, err)
+	assert.Equal(t, "basic", loaded.Config.LoadBalancerSku)
+	assert.Equal(t, "eastus=1", loaded.Config.Location)
+	assert.Equal(t, float32(2.5), loaded.Config.CloudProviderRateLimitQPS)
+	assert.Equal(t, 100, loaded.Config.MaximumLoadBalancerRuleCount)
+	assert.False(t, *loaded.Config.ExcludeMasterFromStandardLB)
+	assert.Equal(t, "file-rg", loaded.Config.ResourceGroup)
+	assert.Equal(t, "env: [cloudProviderRateLimitQPS excludeMasterFromStandardLB maximumLoadBalancerRuleCount]; "+
+		"file: [aadClientId cloud resourceGroup]; flag: [loadBalancerSku location]", loaded.String())
+
+	envLayer, err = GetEnvLayer(func(string) (string, bool) { return "", false })
+	assert.NoError(t, err)
+	assert.Nil(t, envLayer)
+
+	invalidEnv := []string{"AZURE_USE_INSTANCE_METADATA", "AZURE_CLOUD_PROVIDER_BACKOFF_RETRIES", "AZURE_CLOUD_PROVIDER_BACKOFF_JITTER"}
+	for _, key := range invalidEnv {
+		_, err := GetEnvLayer(func(k string) (string, bool) { return "invalid", k == key })
+		assert.Error(t, err, key)
+	}
+	invalidOverrides := []string{"loadBalancerSku", "=standard", "unknownField=value", "cloudProviderBackoff=maybe", "cloudEnvironment={}"}
+	for _, override := range invalidOverrides {
+		_, err := GetFlagLayer([]string{override})
+		assert.Error(t, err, override)
+	}
+}
+
+func TestLoadOverrides(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cloudconfig")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	filePath := filepath.Join(dir, "azure.json")
+	assert.NoError(t, ioutil.WriteFile(filePath, []byte(testFileConfig), 0600))
+
+	os.Setenv("AZURE_AAD_CLIENT_ID", "env-client")
+	os.Setenv("AZURE_LOCATION", "eastus")
+	defer os.Unsetenv("AZURE_AAD_CLIENT_ID")
+	defer os.Unsetenv("AZURE_LOCATION")
+
+	testCases := []struct {
+		desc                 string
+		filePath             string
+		envOverrides         bool
+		overrides            []string
+		expectedNil          bool
+		expectedClientID     string
+		expectedClientFrom   Source
+		expectedLocationFrom Source
+		expectErr            bool
+	}{
+		{
+			desc:                 "environment variables should be ignored if not enabled",
+			filePath:             filePath,
+			expectedClientID:     "file-client",
+			expectedClientFrom:   SourceFile,
+			expectedLocationFrom: SourceFile,
+		},
+		{
+			desc:                 "environment variables should override file",
+			filePath:             filePath,
+			envOverrides:         true,
+			expectedClientID:     "env-client",
+			expectedClientFrom:   SourceEnv,
+			expectedLocationFrom: SourceEnv,
+		},
+		{
+			desc:                 "flag should override environment variables",
+			filePath:             filePath,
+			envOverrides:         true,
+			overrides:            []string{"aadClientId=flag-client"},
+			expectedClientID:     "flag-client",
+			expectedClientFrom:   SourceFlag,
+			expectedLocationFrom: SourceEnv,
+		},
+		{
+			desc:                 "overrides should be loaded without file",
+			overrides:            []string{"aadClientId=flag-client", "location=westus"},
+			expectedClientID:     "flag-client",
+			expectedClientFrom:   SourceFlag,
+			expectedLocationFrom: SourceFlag,
+		},
+		{
+			desc:        "nil should be returned without file and overrides",
+			expectedNil: true,
+		},
+		{
+			desc:      "error should be returned for invalid override",
+			filePath:  filePath,
+			overrides: []string{"aadClientId"},
+			expectErr: true,
+		},
+	}
+
+	for _, test := range testCases {
+		o := NewOptions()
+		o.EnvOverrides = test.envOverrides
+		o.Overrides = test.overrides
+
+		loaded, err := Load(o, test.filePath, nil)
+		if test.expectErr {
+			assert.Error(t, err, test.desc)
+			continue
+		}
+		assert.NoError(t, err, test.desc)
+		if test.expectedNil {
+			assert.Nil(t, loaded, test.desc)
+			continue
+		}
+		assert.Equal(t, test.expectedClientID, loaded.Config.AADClientID, test.desc)
+		assert.Equal(t, test.expectedClientFrom, loaded.Sources["aadClientId"], test.desc)
+		assert.Equal(t, test.expectedLocationFrom, loaded.Sources["location"], test.desc)
+		assert.Equal(t, test.expectedClientFrom != SourceFile, loaded.HasSourceOtherThan(SourceFile), test.desc)
+	}
+}
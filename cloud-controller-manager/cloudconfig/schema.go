@@ -0,0 +1,96 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cloudconfig
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+)
+
+// schemaDraft is the JSON schema draft of Schema.
+const schemaDraft = "http://json-schema.org/draft-07/schema#"
+
+// Schema returns the JSON schema of ConfigV1, generated from its fields, which is published
+// at docs/cloud-config-v1.schema.json.
+func Schema() ([]byte, error) {
+	schema := structSchema(reflect.TypeOf(ConfigV1{}))
+	schema["$schema"] = schemaDraft
+	schema["title"] = "Azure cloud provider config " + APIVersionV1
+	schema["required"] = []string{apiVersionField}
+	schema["properties"].(map[string]interface{})[apiVersionField] = map[string]interface{}{
+		"type": "string",
+		"enum": []string{APIVersionV1},
+	}
+
+	data, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// structSchema returns the schema of a struct, with the fields of embedded structs inlined
+// as encoding/json does. Unknown fields are not allowed, like ConfigV1 is decoded.
+func structSchema(t reflect.Type) map[string]interface{} {
+	properties := make(map[string]interface{})
+	addProperties(t, properties)
+	return map[string]interface{}{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+}
+
+func addProperties(t reflect.Type, properties map[string]interface{}) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Anonymous {
+			addProperties(field.Type, properties)
+			continue
+		}
+
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		properties[name] = typeSchema(field.Type)
+	}
+}
+
+func typeSchema(t reflect.Type) map[string]interface{} {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		return structSchema(t)
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice:
+		return map[string]interface{}{"type": "array", "items": typeSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": typeSchema(t.Elem())}
+	default:
+		return map[string]interface{}{"type": "string"}
+	}
+}
@@ -0,0 +1,64 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cloudconfig
+
+import (
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const schemaPath = "../../docs/cloud-config-v1.schema.json"
+
+var updateSchema = flag.Bool("update-schema", false, "Update the published cloud config schema.")
+
+func TestSchema(t *testing.T) {
+	schema, err := Schema()
+	assert.NoError(t, err)
+
+	if *updateSchema {
+		assert.NoError(t, ioutil.WriteFile(schemaPath, schema, 0644))
+	}
+	published, err := ioutil.ReadFile(schemaPath)
+	assert.NoError(t, err)
+	assert.Equal(t, string(schema), string(published), "schema is out of date, please run hack/update-cloud-config-schema.sh")
+
+	fields := make(map[string]interface{})
+	assert.NoError(t, json.Unmarshal(schema, &fields))
+	properties := fields["properties"].(map[string]interface{})
+	// The schema should have exactly the fields of ConfigV1, which are decoded strictly.
+	var names []string
+	for name := range properties {
+		names = append(names, name)
+	}
+	var expectedNames []string
+	for _, name := range fieldNamesV1 {
+		expectedNames = append(expectedNames, name)
+	}
+	assert.ElementsMatch(t, expectedNames, names)
+	cloudEnvironment := properties["cloudEnvironment"].(map[string]interface{})["properties"].(map[string]interface{})
+	assert.Len(t, cloudEnvironment, reflect.TypeOf(CloudEnvironmentV1{}).NumField())
+	assert.Equal(t, map[string]interface{}{"type": "integer"}, properties["cloudProviderBackoffRetries"])
+	assert.Equal(t, map[string]interface{}{"type": "boolean"}, properties["excludeMasterFromStandardLB"])
+	assert.Equal(t, map[string]interface{}{"type": "number"}, properties["cloudProviderRateLimitQPS"])
+	assert.Equal(t, false, fields["additionalProperties"])
+	assert.Equal(t, false, properties["cloudEnvironment"].(map[string]interface{})["additionalProperties"])
+}
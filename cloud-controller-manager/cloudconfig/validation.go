@@ -0,0 +1,236 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cloudconfig
+
+import (
+	"encoding/json"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	azureprovider "k8s.io/kubernetes/pkg/cloudprovider/providers/azure"
+	"k8s.io/kubernetes/pkg/cloudprovider/providers/azure/auth"
+)
+
+// The following values mirror the unexported defaults and candidates of the Azure cloud provider.
+const (
+	rateLimitQPSDefault          = 1.0
+	rateLimitBucketDefault       = 5
+	backoffRetriesDefault        = 6
+	backoffExponentDefault       = 1.5
+	backoffDurationDefault       = 5 // in seconds
+	backoffJitterDefault         = 1.0
+	maximumLoadBalancerRuleCount = 250
+
+	vmTypeVMSS     = "vmss"
+	vmTypeStandard = "standard"
+
+	backoffModeDefault = "default"
+	backoffModeV2      = "v2"
+
+	loadBalancerSkuBasic    = "basic"
+	loadBalancerSkuStandard = "standard"
+
+	redactedValue = "REDACTED"
+)
+
+// secretFields are the fields redacted by Redact.
+var secretFields = []string{"aadClientSecret", "aadClientCertPassword"}
+
+// SetDefaults applies the same defaults to config as the Azure cloud provider does in NewCloud.
+func SetDefaults(config *azureprovider.Config) {
+	config.ResourceGroup = strings.ToLower(config.ResourceGroup)
+	if config.VMType == "" {
+		config.VMType = vmTypeStandard
+	}
+
+	if config.CloudProviderRateLimit {
+		if config.CloudProviderRateLimitQPS == 0 {
+			config.CloudProviderRateLimitQPS = rateLimitQPSDefault
+		}
+		if config.CloudProviderRateLimitBucket == 0 {
+			config.CloudProviderRateLimitBucket = rateLimitBucketDefault
+		}
+		if config.CloudProviderRateLimitQPSWrite == 0 {
+			config.CloudProviderRateLimitQPSWrite = rateLimitQPSDefault
+		}
+		if config.CloudProviderRateLimitBucketWrite == 0 {
+			config.CloudProviderRateLimitBucketWrite = rateLimitBucketDefault
+		}
+	}
+
+	if config.CloudProviderBackoff {
+		if config.CloudProviderBackoffRetries == 0 {
+			config.CloudProviderBackoffRetries = backoffRetriesDefault
+		}
+		if config.CloudProviderBackoffDuration == 0 {
+			config.CloudProviderBackoffDuration = backoffDurationDefault
+		}
+		if config.CloudProviderBackoffExponent == 0 {
+			config.CloudProviderBackoffExponent = backoffExponentDefault
+		}
+		if config.CloudProviderBackoffJitter == 0 {
+			config.CloudProviderBackoffJitter = backoffJitterDefault
+		}
+	} else {
+		config.CloudProviderBackoffRetries = 1
+		config.CloudProviderBackoffDuration = backoffDurationDefault
+	}
+
+	if strings.EqualFold(config.LoadBalancerSku, loadBalancerSkuStandard) {
+		if config.ExcludeMasterFromStandardLB == nil {
+			excludeMaster := true
+			config.ExcludeMasterFromStandardLB = &excludeMaster
+		}
+		if config.DisableOutboundSNAT == nil {
+			disableOutboundSNAT := false
+			config.DisableOutboundSNAT = &disableOutboundSNAT
+		}
+	}
+
+	if config.MaximumLoadBalancerRuleCount == 0 {
+		config.MaximumLoadBalancerRuleCount = maximumLoadBalancerRuleCount
+	}
+}
+
+// Validate checks that the defaulted config and the custom cloud environment are consistent.
+// Field paths are the JSON names of the fields.
+func Validate(config *azureprovider.Config, environment *EnvironmentConfig) field.ErrorList {
+	var errs field.ErrorList
+
+	if environment != nil && environment.IsCustom() {
+		// The cloud name is overridden by the custom cloud environment.
+		if err := environment.Validate(); err != nil {
+			errs = append(errs, field.Invalid(field.NewPath("cloudEnvironment"), "", err.Error()))
+		}
+	} else if _, err := auth.ParseAzureEnvironment(config.Cloud); err != nil {
+		errs = append(errs, field.Invalid(field.NewPath("cloud"), config.Cloud, err.Error()))
+	}
+	errs = append(errs, validateAuth(config)...)
+	for _, required := range []struct {
+		name  string
+		value string
+	}{
+		{"subscriptionId", config.SubscriptionID},
+		{"resourceGroup", config.ResourceGroup},
+		{"location", config.Location},
+	} {
+		if required.value == "" {
+			errs = append(errs, field.Required(field.NewPath(required.name), ""))
+		}
+	}
+
+	if !strings.EqualFold(config.VMType, vmTypeStandard) && !strings.EqualFold(config.VMType, vmTypeVMSS) {
+		errs = append(errs, field.NotSupported(field.NewPath("vmType"), config.VMType, []string{vmTypeStandard, vmTypeVMSS}))
+	} else if strings.EqualFold(config.VMType, vmTypeStandard) && config.PrimaryScaleSetName != "" {
+		errs = append(errs, field.Invalid(field.NewPath("primaryScaleSetName"), config.PrimaryScaleSetName, "only supported when vmType is vmss"))
+	}
+
+	switch {
+	case config.LoadBalancerSku == "" || strings.EqualFold(config.LoadBalancerSku, loadBalancerSkuBasic):
+		if config.ExcludeMasterFromStandardLB != nil {
+			errs = append(errs, field.Invalid(field.NewPath("excludeMasterFromStandardLB"), *config.ExcludeMasterFromStandardLB, "only supported when loadBalancerSku is standard"))
+		}
+		if config.DisableOutboundSNAT != nil && *config.DisableOutboundSNAT {
+			errs = append(errs, field.Invalid(field.NewPath("disableOutboundSNAT"), *config.DisableOutboundSNAT, "only supported when loadBalancerSku is standard"))
+		}
+	case !strings.EqualFold(config.LoadBalancerSku, loadBalancerSkuStandard):
+		errs = append(errs, field.NotSupported(field.NewPath("loadBalancerSku"), config.LoadBalancerSku, []string{loadBalancerSkuBasic, loadBalancerSkuStandard}))
+	}
+
+	if config.CloudProviderRateLimit {
+		errs = append(errs, validatePositive(field.NewPath("cloudProviderRateLimitQPS"), float64(config.CloudProviderRateLimitQPS))...)
+		errs = append(errs, validatePositive(field.NewPath("cloudProviderRateLimitBucket"), float64(config.CloudProviderRateLimitBucket))...)
+		errs = append(errs, validatePositive(field.NewPath("cloudProviderRateLimitQPSWrite"), float64(config.CloudProviderRateLimitQPSWrite))...)
+		errs = append(errs, validatePositive(field.NewPath("cloudProviderRateLimitBucketWrite"), float64(config.CloudProviderRateLimitBucketWrite))...)
+	}
+
+	if config.CloudProviderBackoffMode != "" && config.CloudProviderBackoffMode != backoffModeDefault && config.CloudProviderBackoffMode != backoffModeV2 {
+		errs = append(errs, field.NotSupported(field.NewPath("cloudProviderBackoffMode"), config.CloudProviderBackoffMode, []string{backoffModeDefault, backoffModeV2}))
+	}
+	if config.CloudProviderBackoff {
+		errs = append(errs, validatePositive(field.NewPath("cloudProviderBackoffRetries"), float64(config.CloudProviderBackoffRetries))...)
+		errs = append(errs, validatePositive(field.NewPath("cloudProviderBackoffDuration"), float64(config.CloudProviderBackoffDuration))...)
+		if config.CloudProviderBackoffExponent < 1 {
+			errs = append(errs, field.Invalid(field.NewPath("cloudProviderBackoffExponent"), config.CloudProviderBackoffExponent, "must be greater than or equal to 1"))
+		}
+		if config.CloudProviderBackoffJitter < 0 {
+			errs = append(errs, field.Invalid(field.NewPath("cloudProviderBackoffJitter"), config.CloudProviderBackoffJitter, "must be greater than or equal to 0"))
+		}
+	}
+
+	errs = append(errs, validatePositive(field.NewPath("maximumLoadBalancerRuleCount"), float64(config.MaximumLoadBalancerRuleCount))...)
+	return errs
+}
+
+// validateAuth checks the fields required by the auth mode, which is chosen in the same
+// order as auth.GetServicePrincipalToken: managed identity, client secret, client certificate.
+func validateAuth(config *azureprovider.Config) field.ErrorList {
+	// The managed identity token is requested from instance metadata service, which
+	// doesn't need the tenant.
+	if config.UseManagedIdentityExtension {
+		return nil
+	}
+
+	var errs field.ErrorList
+	if config.TenantID == "" {
+		errs = append(errs, field.Required(field.NewPath("tenantId"), "required unless useManagedIdentityExtension is true"))
+	}
+	if config.AADClientID == "" {
+		errs = append(errs, field.Required(field.NewPath("aadClientId"), "required unless useManagedIdentityExtension is true"))
+	}
+	if config.AADClientSecret != "" {
+		return errs
+	}
+	if config.AADClientCertPath == "" && config.AADClientCertPassword == "" {
+		errs = append(errs, field.Required(field.NewPath("aadClientSecret"), "one of aadClientSecret, aadClientCertPath or useManagedIdentityExtension is required"))
+	} else if config.AADClientCertPath == "" {
+		errs = append(errs, field.Required(field.NewPath("aadClientCertPath"), "required with aadClientCertPassword"))
+	} else if config.AADClientCertPassword == "" {
+		errs = append(errs, field.Required(field.NewPath("aadClientCertPassword"), "required with aadClientCertPath"))
+	}
+	return errs
+}
+
+func validatePositive(path *field.Path, value float64) field.ErrorList {
+	if value <= 0 {
+		return field.ErrorList{field.Invalid(path, value, "must be greater than 0")}
+	}
+	return nil
+}
+
+// Redact returns the fields of the config and the custom cloud environment, with
+// secrets replaced by "REDACTED".
+func Redact(config *azureprovider.Config, environment *EnvironmentConfig) (map[string]interface{}, error) {
+	data, err := json.Marshal(struct {
+		*azureprovider.Config
+		*EnvironmentConfig
+	}{config, environment})
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make(map[string]interface{})
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	for _, name := range secretFields {
+		if value, ok := fields[name]; ok && value != "" {
+			fields[name] = redactedValue
+		}
+	}
+	return fields, nil
+}
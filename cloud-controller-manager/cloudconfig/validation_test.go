@@ -0,0 +1,262 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cloudconfig
+
+import (
+	"testing"
+
+	"github.com/Azure/go-autorest/autorest/azure"
+	"github.com/stretchr/testify/assert"
+
+	azureprovider "k8s.io/kubernetes/pkg/cloudprovider/providers/azure"
+	"k8s.io/kubernetes/pkg/cloudprovider/providers/azure/auth"
+)
+
+func newValidConfig() *azureprovider.Config {
+	return &azureprovider.Config{
+		AzureAuthConfig: auth.AzureAuthConfig{
+			TenantID:        "tenant",
+			SubscriptionID:  "subscription",
+			AADClientID:     "client",
+			AADClientSecret: "secret",
+		},
+		ResourceGroup: "RG",
+		Location:      "westus2",
+	}
+}
+
+func TestSetDefaults(t *testing.T) {
+	config := newValidConfig()
+	config.LoadBalancerSku = "Standard"
+	config.CloudProviderRateLimit = true
+	config.CloudProviderRateLimitQPS = 10
+	config.CloudProviderBackoff = true
+	SetDefaults(config)
+
+	assert.Equal(t, "rg", config.ResourceGroup)
+	assert.Equal(t, vmTypeStandard, config.VMType)
+	assert.Equal(t, float32(10), config.CloudProviderRateLimitQPS)
+	assert.Equal(t, rateLimitBucketDefault, config.CloudProviderRateLimitBucket)
+	assert.Equal(t, float32(rateLimitQPSDefault), config.CloudProviderRateLimitQPSWrite)
+	assert.Equal(t, backoffRetriesDefault, config.CloudProviderBackoffRetries)
+	assert.Equal(t, backoffExponentDefault, config.CloudProviderBackoffExponent)
+	assert.Equal(t, true, *config.ExcludeMasterFromStandardLB)
+	assert.Equal(t, false, *config.DisableOutboundSNAT)
+	assert.Equal(t, maximumLoadBalancerRuleCount, config.MaximumLoadBalancerRuleCount)
+
+	config = newValidConfig()
+	SetDefaults(config)
+	assert.Equal(t, 1, config.CloudProviderBackoffRetries)
+	assert.Nil(t, config.ExcludeMasterFromStandardLB)
+}
+
+func TestValidate(t *testing.T) {
+	trueValue := true
+
+	testCases := []struct {
+		desc           string
+		modify         func(config *azureprovider.Config)
+		environment    *EnvironmentConfig
+		expectedFields []string
+	}{
+		{
+			desc:   "valid config should pass",
+			modify: func(config *azureprovider.Config) {},
+		},
+		{
+			desc: "managed identity should not require client credentials",
+			modify: func(config *azureprovider.Config) {
+				config.AADClientID = ""
+				config.AADClientSecret = ""
+				config.UseManagedIdentityExtension = true
+			},
+		},
+		{
+			desc: "managed identity should not require tenant",
+			modify: func(config *azureprovider.Config) {
+				config.TenantID = ""
+				config.AADClientID = ""
+				config.AADClientSecret = ""
+				config.UseManagedIdentityExtension = true
+			},
+		},
+		{
+			desc: "client certificate without tenant should be reported",
+			modify: func(config *azureprovider.Config) {
+				config.TenantID = ""
+				config.AADClientSecret = ""
+				config.AADClientCertPath = "/etc/kubernetes/client.pfx"
+				config.AADClientCertPassword = "password"
+			},
+			expectedFields: []string{"tenantId"},
+		},
+		{
+			desc: "client certificate should be accepted",
+			modify: func(config *azureprovider.Config) {
+				config.AADClientSecret = ""
+				config.AADClientCertPath = "/etc/kubernetes/client.pfx"
+				config.AADClientCertPassword = "password"
+			},
+		},
+		{
+			desc: "missing credentials should be reported",
+			modify: func(config *azureprovider.Config) {
+				config.AADClientSecret = ""
+			},
+			expectedFields: []string{"aadClientSecret"},
+		},
+		{
+			desc: "client certificate without password should be reported",
+			modify: func(config *azureprovider.Config) {
+				config.AADClientSecret = ""
+				config.AADClientCertPath = "/etc/kubernetes/client.pfx"
+			},
+			expectedFields: []string{"aadClientCertPassword"},
+		},
+		{
+			desc: "missing required fields should be reported",
+			modify: func(config *azureprovider.Config) {
+				config.TenantID = ""
+				config.AADClientID = ""
+				config.SubscriptionID = ""
+				config.ResourceGroup = ""
+				config.Location = ""
+			},
+			expectedFields: []string{"tenantId", "aadClientId", "subscriptionId", "resourceGroup", "location"},
+		},
+		{
+			desc: "unknown cloud should be reported",
+			modify: func(config *azureprovider.Config) {
+				config.Cloud = "AzureMoonCloud"
+			},
+			expectedFields: []string{"cloud"},
+		},
+		{
+			desc: "cloud should be ignored with custom cloud environment",
+			modify: func(config *azureprovider.Config) {
+				config.Cloud = "AzureMoonCloud"
+			},
+			environment: &EnvironmentConfig{CloudEnvironment: &azure.Environment{
+				Name:                       "AzureStackHub",
+				ResourceManagerEndpoint:    "https://management.local.azurestack.external/",
+				ActiveDirectoryEndpoint:    "https://login.microsoftonline.com/",
+				ServiceManagementEndpoint:  "https://management.azurestackhub.onmicrosoft.com/0a1b2c3d",
+				StorageEndpointSuffix:      "local.azurestack.external",
+				ResourceManagerVMDNSSuffix: "cloudapp.azurestack.external",
+			}},
+		},
+		{
+			desc:           "incomplete custom cloud environment should be reported",
+			modify:         func(config *azureprovider.Config) {},
+			environment:    &EnvironmentConfig{CloudEnvironment: &azure.Environment{Name: "AzureStackHub"}},
+			expectedFields: []string{"cloudEnvironment"},
+		},
+		{
+			desc: "unknown vmType should be reported",
+			modify: func(config *azureprovider.Config) {
+				config.VMType = "vmssflex"
+			},
+			expectedFields: []string{"vmType"},
+		},
+		{
+			desc: "primaryScaleSetName with standard vmType should be reported",
+			modify: func(config *azureprovider.Config) {
+				config.PrimaryScaleSetName = "ss"
+			},
+			expectedFields: []string{"primaryScaleSetName"},
+		},
+		{
+			desc: "standard only options with basic SKU should be reported",
+			modify: func(config *azureprovider.Config) {
+				config.ExcludeMasterFromStandardLB = &trueValue
+				config.DisableOutboundSNAT = &trueValue
+			},
+			expectedFields: []string{"excludeMasterFromStandardLB", "disableOutboundSNAT"},
+		},
+		{
+			desc: "standard only options with standard SKU should pass",
+			modify: func(config *azureprovider.Config) {
+				config.LoadBalancerSku = "standard"
+				config.DisableOutboundSNAT = &trueValue
+			},
+		},
+		{
+			desc: "unknown SKU should be reported",
+			modify: func(config *azureprovider.Config) {
+				config.LoadBalancerSku = "premium"
+			},
+			expectedFields: []string{"loadBalancerSku"},
+		},
+		{
+			desc: "negative rate limits should be reported",
+			modify: func(config *azureprovider.Config) {
+				config.CloudProviderRateLimit = true
+				config.CloudProviderRateLimitQPS = -1
+				config.CloudProviderRateLimitBucketWrite = -5
+			},
+			expectedFields: []string{"cloudProviderRateLimitQPS", "cloudProviderRateLimitBucketWrite"},
+		},
+		{
+			desc: "invalid backoff should be reported",
+			modify: func(config *azureprovider.Config) {
+				config.CloudProviderBackoff = true
+				config.CloudProviderBackoffMode = "v3"
+				config.CloudProviderBackoffRetries = -1
+				config.CloudProviderBackoffExponent = 0.5
+				config.CloudProviderBackoffJitter = -1
+			},
+			expectedFields: []string{"cloudProviderBackoffMode", "cloudProviderBackoffRetries", "cloudProviderBackoffExponent", "cloudProviderBackoffJitter"},
+		},
+		{
+			desc: "negative maximumLoadBalancerRuleCount should be reported",
+			modify: func(config *azureprovider.Config) {
+				config.MaximumLoadBalancerRuleCount = -1
+			},
+			expectedFields: []string{"maximumLoadBalancerRuleCount"},
+		},
+	}
+
+	for _, test := range testCases {
+		config := newValidConfig()
+		test.modify(config)
+		SetDefaults(config)
+
+		var fields []string
+		for _, err := range Validate(config, test.environment) {
+			fields = append(fields, err.Field)
+		}
+		assert.Equal(t, test.expectedFields, fields, test.desc)
+	}
+}
+
+func TestRedact(t *testing.T) {
+	config := newValidConfig()
+	config.AADClientCertPassword = "password"
+	fields, err := Redact(config, &EnvironmentConfig{CloudEnvironmentFilePath: "/etc/kubernetes/env.json"})
+	assert.NoError(t, err)
+
+	assert.Equal(t, "REDACTED", fields["aadClientSecret"])
+	assert.Equal(t, "REDACTED", fields["aadClientCertPassword"])
+	assert.Equal(t, "client", fields["aadClientId"])
+	assert.Equal(t, "/etc/kubernetes/env.json", fields["cloudEnvironmentFilePath"])
+	assert.Contains(t, fields, "vmType")
+
+	fields, err = Redact(newValidConfig(), &EnvironmentConfig{})
+	assert.NoError(t, err)
+	assert.Equal(t, "", fields["aadClientCertPassword"])
+	assert.NotContains(t, fields, "cloudEnvironmentFilePath")
+}
@@ -0,0 +1,351 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cloudconfig
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Azure/go-autorest/autorest/azure"
+	azureprovider "k8s.io/kubernetes/pkg/cloudprovider/providers/azure"
+	"k8s.io/kubernetes/pkg/cloudprovider/providers/azure/auth"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// APIVersionV1 is the apiVersion of ConfigV1.
+	APIVersionV1 = "cloudprovider.azure.k8s.io/v1"
+
+	apiVersionField = "apiVersion"
+)
+
+// ConfigV1 is the v1 cloud config. It has the fields of the Azure cloud provider config
+// and the custom cloud environment, and is decoded strictly: unknown fields, fields in a
+// different case and duplicated fields are rejected. It is converted to and from the config
+// of the vendored provider field by field, so that changes of the provider config don't
+// change the v1 API.
+type ConfigV1 struct {
+	APIVersion string `json:"apiVersion" yaml:"apiVersion"`
+
+	// Auth fields.
+	Cloud                       string `json:"cloud" yaml:"cloud"`
+	TenantID                    string `json:"tenantId" yaml:"tenantId"`
+	AADClientID                 string `json:"aadClientId" yaml:"aadClientId"`
+	AADClientSecret             string `json:"aadClientSecret" yaml:"aadClientSecret"`
+	AADClientCertPath           string `json:"aadClientCertPath" yaml:"aadClientCertPath"`
+	AADClientCertPassword       string `json:"aadClientCertPassword" yaml:"aadClientCertPassword"`
+	UseManagedIdentityExtension bool   `json:"useManagedIdentityExtension" yaml:"useManagedIdentityExtension"`
+	UserAssignedIdentityID      string `json:"userAssignedIdentityID" yaml:"userAssignedIdentityID"`
+	SubscriptionID              string `json:"subscriptionId" yaml:"subscriptionId"`
+
+	// Cluster resources.
+	ResourceGroup              string `json:"resourceGroup" yaml:"resourceGroup"`
+	Location                   string `json:"location" yaml:"location"`
+	VnetName                   string `json:"vnetName" yaml:"vnetName"`
+	VnetResourceGroup          string `json:"vnetResourceGroup" yaml:"vnetResourceGroup"`
+	SubnetName                 string `json:"subnetName" yaml:"subnetName"`
+	SecurityGroupName          string `json:"securityGroupName" yaml:"securityGroupName"`
+	RouteTableName             string `json:"routeTableName" yaml:"routeTableName"`
+	PrimaryAvailabilitySetName string `json:"primaryAvailabilitySetName" yaml:"primaryAvailabilitySetName"`
+	VMType                     string `json:"vmType" yaml:"vmType"`
+	PrimaryScaleSetName        string `json:"primaryScaleSetName" yaml:"primaryScaleSetName"`
+
+	// Backoff and rate limits.
+	CloudProviderBackoff              bool    `json:"cloudProviderBackoff" yaml:"cloudProviderBackoff"`
+	CloudProviderBackoffRetries       int     `json:"cloudProviderBackoffRetries" yaml:"cloudProviderBackoffRetries"`
+	CloudProviderBackoffExponent      float64 `json:"cloudProviderBackoffExponent" yaml:"cloudProviderBackoffExponent"`
+	CloudProviderBackoffDuration      int     `json:"cloudProviderBackoffDuration" yaml:"cloudProviderBackoffDuration"`
+	CloudProviderBackoffJitter        float64 `json:"cloudProviderBackoffJitter" yaml:"cloudProviderBackoffJitter"`
+	CloudProviderBackoffMode          string  `json:"cloudProviderBackoffMode" yaml:"cloudProviderBackoffMode"`
+	CloudProviderRateLimit            bool    `json:"cloudProviderRateLimit" yaml:"cloudProviderRateLimit"`
+	CloudProviderRateLimitQPS         float32 `json:"cloudProviderRateLimitQPS" yaml:"cloudProviderRateLimitQPS"`
+	CloudProviderRateLimitBucket      int     `json:"cloudProviderRateLimitBucket" yaml:"cloudProviderRateLimitBucket"`
+	CloudProviderRateLimitQPSWrite    float32 `json:"cloudProviderRateLimitQPSWrite" yaml:"cloudProviderRateLimitQPSWrite"`
+	CloudProviderRateLimitBucketWrite int     `json:"cloudProviderRateLimitBucketWrite" yaml:"cloudProviderRateLimitBucketWrite"`
+
+	UseInstanceMetadata bool `json:"useInstanceMetadata" yaml:"useInstanceMetadata"`
+
+	// Load balancers.
+	LoadBalancerSku              string `json:"loadBalancerSku" yaml:"loadBalancerSku"`
+	ExcludeMasterFromStandardLB  *bool  `json:"excludeMasterFromStandardLB" yaml:"excludeMasterFromStandardLB"`
+	DisableOutboundSNAT          *bool  `json:"disableOutboundSNAT" yaml:"disableOutboundSNAT"`
+	MaximumLoadBalancerRuleCount int    `json:"maximumLoadBalancerRuleCount" yaml:"maximumLoadBalancerRuleCount"`
+
+	// Custom cloud environment, see EnvironmentConfig.
+	CloudEnvironmentFilePath string              `json:"cloudEnvironmentFilePath,omitempty" yaml:"cloudEnvironmentFilePath,omitempty"`
+	CloudEnvironment         *CloudEnvironmentV1 `json:"cloudEnvironment,omitempty" yaml:"cloudEnvironment,omitempty"`
+}
+
+// CloudEnvironmentV1 holds the endpoints of a custom cloud environment in the v1 cloud config.
+type CloudEnvironmentV1 struct {
+	Name                         string `json:"name" yaml:"name"`
+	ManagementPortalURL          string `json:"managementPortalURL" yaml:"managementPortalURL"`
+	PublishSettingsURL           string `json:"publishSettingsURL" yaml:"publishSettingsURL"`
+	ServiceManagementEndpoint    string `json:"serviceManagementEndpoint" yaml:"serviceManagementEndpoint"`
+	ResourceManagerEndpoint      string `json:"resourceManagerEndpoint" yaml:"resourceManagerEndpoint"`
+	ActiveDirectoryEndpoint      string `json:"activeDirectoryEndpoint" yaml:"activeDirectoryEndpoint"`
+	GalleryEndpoint              string `json:"galleryEndpoint" yaml:"galleryEndpoint"`
+	KeyVaultEndpoint             string `json:"keyVaultEndpoint" yaml:"keyVaultEndpoint"`
+	GraphEndpoint                string `json:"graphEndpoint" yaml:"graphEndpoint"`
+	ServiceBusEndpoint           string `json:"serviceBusEndpoint" yaml:"serviceBusEndpoint"`
+	BatchManagementEndpoint      string `json:"batchManagementEndpoint" yaml:"batchManagementEndpoint"`
+	StorageEndpointSuffix        string `json:"storageEndpointSuffix" yaml:"storageEndpointSuffix"`
+	SQLDatabaseDNSSuffix         string `json:"sqlDatabaseDNSSuffix" yaml:"sqlDatabaseDNSSuffix"`
+	TrafficManagerDNSSuffix      string `json:"trafficManagerDNSSuffix" yaml:"trafficManagerDNSSuffix"`
+	KeyVaultDNSSuffix            string `json:"keyVaultDNSSuffix" yaml:"keyVaultDNSSuffix"`
+	ServiceBusEndpointSuffix     string `json:"serviceBusEndpointSuffix" yaml:"serviceBusEndpointSuffix"`
+	ServiceManagementVMDNSSuffix string `json:"serviceManagementVMDNSSuffix" yaml:"serviceManagementVMDNSSuffix"`
+	ResourceManagerVMDNSSuffix   string `json:"resourceManagerVMDNSSuffix" yaml:"resourceManagerVMDNSSuffix"`
+	ContainerRegistryDNSSuffix   string `json:"containerRegistryDNSSuffix" yaml:"containerRegistryDNSSuffix"`
+	TokenAudience                string `json:"tokenAudience" yaml:"tokenAudience"`
+}
+
+// typeMeta is used to read the apiVersion of a cloud config.
+type typeMeta struct {
+	APIVersion string `json:"apiVersion"`
+}
+
+// ConvertToV1 converts the config and the custom cloud environment to the v1 cloud config.
+func ConvertToV1(config *azureprovider.Config, environment *EnvironmentConfig) *ConfigV1 {
+	v1 := &ConfigV1{
+		APIVersion: APIVersionV1,
+
+		Cloud:                       config.Cloud,
+		TenantID:                    config.TenantID,
+		AADClientID:                 config.AADClientID,
+		AADClientSecret:             config.AADClientSecret,
+		AADClientCertPath:           config.AADClientCertPath,
+		AADClientCertPassword:       config.AADClientCertPassword,
+		UseManagedIdentityExtension: config.UseManagedIdentityExtension,
+		UserAssignedIdentityID:      config.UserAssignedIdentityID,
+		SubscriptionID:              config.SubscriptionID,
+
+		ResourceGroup:              config.ResourceGroup,
+		Location:                   config.Location,
+		VnetName:                   config.VnetName,
+		VnetResourceGroup:          config.VnetResourceGroup,
+		SubnetName:                 config.SubnetName,
+		SecurityGroupName:          config.SecurityGroupName,
+		RouteTableName:             config.RouteTableName,
+		PrimaryAvailabilitySetName: config.PrimaryAvailabilitySetName,
+		VMType:                     config.VMType,
+		PrimaryScaleSetName:        config.PrimaryScaleSetName,
+
+		CloudProviderBackoff:              config.CloudProviderBackoff,
+		CloudProviderBackoffRetries:       config.CloudProviderBackoffRetries,
+		CloudProviderBackoffExponent:      config.CloudProviderBackoffExponent,
+		CloudProviderBackoffDuration:      config.CloudProviderBackoffDuration,
+		CloudProviderBackoffJitter:        config.CloudProviderBackoffJitter,
+		CloudProviderBackoffMode:          config.CloudProviderBackoffMode,
+		CloudProviderRateLimit:            config.CloudProviderRateLimit,
+		CloudProviderRateLimitQPS:         config.CloudProviderRateLimitQPS,
+		CloudProviderRateLimitBucket:      config.CloudProviderRateLimitBucket,
+		CloudProviderRateLimitQPSWrite:    config.CloudProviderRateLimitQPSWrite,
+		CloudProviderRateLimitBucketWrite: config.CloudProviderRateLimitBucketWrite,
+
+		UseInstanceMetadata: config.UseInstanceMetadata,
+
+		LoadBalancerSku:              config.LoadBalancerSku,
+		ExcludeMasterFromStandardLB:  copyBool(config.ExcludeMasterFromStandardLB),
+		DisableOutboundSNAT:          copyBool(config.DisableOutboundSNAT),
+		MaximumLoadBalancerRuleCount: config.MaximumLoadBalancerRuleCount,
+	}
+	if environment != nil {
+		v1.CloudEnvironmentFilePath = environment.CloudEnvironmentFilePath
+		v1.CloudEnvironment = convertEnvironmentToV1(environment.CloudEnvironment)
+	}
+	return v1
+}
+
+// ConvertFromV1 converts the v1 cloud config to the config read by the Azure cloud
+// provider and the custom cloud environment.
+func ConvertFromV1(v1 *ConfigV1) (*azureprovider.Config, *EnvironmentConfig) {
+	config := &azureprovider.Config{
+		AzureAuthConfig: auth.AzureAuthConfig{
+			Cloud:                       v1.Cloud,
+			TenantID:                    v1.TenantID,
+			AADClientID:                 v1.AADClientID,
+			AADClientSecret:             v1.AADClientSecret,
+			AADClientCertPath:           v1.AADClientCertPath,
+			AADClientCertPassword:       v1.AADClientCertPassword,
+			UseManagedIdentityExtension: v1.UseManagedIdentityExtension,
+			UserAssignedIdentityID:      v1.UserAssignedIdentityID,
+			SubscriptionID:              v1.SubscriptionID,
+		},
+
+		ResourceGroup:              v1.ResourceGroup,
+		Location:                   v1.Location,
+		VnetName:                   v1.VnetName,
+		VnetResourceGroup:          v1.VnetResourceGroup,
+		SubnetName:                 v1.SubnetName,
+		SecurityGroupName:          v1.SecurityGroupName,
+		RouteTableName:             v1.RouteTableName,
+		PrimaryAvailabilitySetName: v1.PrimaryAvailabilitySetName,
+		VMType:                     v1.VMType,
+		PrimaryScaleSetName:        v1.PrimaryScaleSetName,
+
+		CloudProviderBackoff:              v1.CloudProviderBackoff,
+		CloudProviderBackoffRetries:       v1.CloudProviderBackoffRetries,
+		CloudProviderBackoffExponent:      v1.CloudProviderBackoffExponent,
+		CloudProviderBackoffDuration:      v1.CloudProviderBackoffDuration,
+		CloudProviderBackoffJitter:        v1.CloudProviderBackoffJitter,
+		CloudProviderBackoffMode:          v1.CloudProviderBackoffMode,
+		CloudProviderRateLimit:            v1.CloudProviderRateLimit,
+		CloudProviderRateLimitQPS:         v1.CloudProviderRateLimitQPS,
+		CloudProviderRateLimitBucket:      v1.CloudProviderRateLimitBucket,
+		CloudProviderRateLimitQPSWrite:    v1.CloudProviderRateLimitQPSWrite,
+		CloudProviderRateLimitBucketWrite: v1.CloudProviderRateLimitBucketWrite,
+
+		UseInstanceMetadata: v1.UseInstanceMetadata,
+
+		LoadBalancerSku:              v1.LoadBalancerSku,
+		ExcludeMasterFromStandardLB:  copyBool(v1.ExcludeMasterFromStandardLB),
+		DisableOutboundSNAT:          copyBool(v1.DisableOutboundSNAT),
+		MaximumLoadBalancerRuleCount: v1.MaximumLoadBalancerRuleCount,
+	}
+	environment := &EnvironmentConfig{
+		CloudEnvironmentFilePath: v1.CloudEnvironmentFilePath,
+		CloudEnvironment:         convertEnvironmentFromV1(v1.CloudEnvironment),
+	}
+	return config, environment
+}
+
+// SetDefaultsV1 applies the defaults of the Azure cloud provider to the v1 cloud config.
+func SetDefaultsV1(v1 *ConfigV1) {
+	config, environment := ConvertFromV1(v1)
+	SetDefaults(config)
+	apiVersion := v1.APIVersion
+	*v1 = *ConvertToV1(config, environment)
+	if apiVersion != "" {
+		v1.APIVersion = apiVersion
+	}
+}
+
+func copyBool(b *bool) *bool {
+	if b == nil {
+		return nil
+	}
+	c := *b
+	return &c
+}
+
+func convertEnvironmentToV1(env *azure.Environment) *CloudEnvironmentV1 {
+	if env == nil {
+		return nil
+	}
+	return &CloudEnvironmentV1{
+		Name:                         env.Name,
+		ManagementPortalURL:          env.ManagementPortalURL,
+		PublishSettingsURL:           env.PublishSettingsURL,
+		ServiceManagementEndpoint:    env.ServiceManagementEndpoint,
+		ResourceManagerEndpoint:      env.ResourceManagerEndpoint,
+		ActiveDirectoryEndpoint:      env.ActiveDirectoryEndpoint,
+		GalleryEndpoint:              env.GalleryEndpoint,
+		KeyVaultEndpoint:             env.KeyVaultEndpoint,
+		GraphEndpoint:                env.GraphEndpoint,
+		ServiceBusEndpoint:           env.ServiceBusEndpoint,
+		BatchManagementEndpoint:      env.BatchManagementEndpoint,
+		StorageEndpointSuffix:        env.StorageEndpointSuffix,
+		SQLDatabaseDNSSuffix:         env.SQLDatabaseDNSSuffix,
+		TrafficManagerDNSSuffix:      env.TrafficManagerDNSSuffix,
+		KeyVaultDNSSuffix:            env.KeyVaultDNSSuffix,
+		ServiceBusEndpointSuffix:     env.ServiceBusEndpointSuffix,
+		ServiceManagementVMDNSSuffix: env.ServiceManagementVMDNSSuffix,
+		ResourceManagerVMDNSSuffix:   env.ResourceManagerVMDNSSuffix,
+		ContainerRegistryDNSSuffix:   env.ContainerRegistryDNSSuffix,
+		TokenAudience:                env.TokenAudience,
+	}
+}
+
+func convertEnvironmentFromV1(env *CloudEnvironmentV1) *azure.Environment {
+	if env == nil {
+		return nil
+	}
+	return &azure.Environment{
+		Name:                         env.Name,
+		ManagementPortalURL:          env.ManagementPortalURL,
+		PublishSettingsURL:           env.PublishSettingsURL,
+		ServiceManagementEndpoint:    env.ServiceManagementEndpoint,
+		ResourceManagerEndpoint:      env.ResourceManagerEndpoint,
+		ActiveDirectoryEndpoint:      env.ActiveDirectoryEndpoint,
+		GalleryEndpoint:              env.GalleryEndpoint,
+		KeyVaultEndpoint:             env.KeyVaultEndpoint,
+		GraphEndpoint:                env.GraphEndpoint,
+		ServiceBusEndpoint:           env.ServiceBusEndpoint,
+		BatchManagementEndpoint:      env.BatchManagementEndpoint,
+		StorageEndpointSuffix:        env.StorageEndpointSuffix,
+		SQLDatabaseDNSSuffix:         env.SQLDatabaseDNSSuffix,
+		TrafficManagerDNSSuffix:      env.TrafficManagerDNSSuffix,
+		KeyVaultDNSSuffix:            env.KeyVaultDNSSuffix,
+		ServiceBusEndpointSuffix:     env.ServiceBusEndpointSuffix,
+		ServiceManagementVMDNSSuffix: env.ServiceManagementVMDNSSuffix,
+		ResourceManagerVMDNSSuffix:   env.ResourceManagerVMDNSSuffix,
+		ContainerRegistryDNSSuffix:   env.ContainerRegistryDNSSuffix,
+		TokenAudience:                env.TokenAudience,
+	}
+}
+
+// decodeLayer decodes the fields set in the layer, keyed by their canonical names. Unversioned
+// configs are decoded as the Azure cloud provider does, i.e. unknown fields are ignored, and
+// they are returned together with warnings for them.
+func decodeLayer(layer Layer) (fields map[string]interface{}, unknown []string, warnings []string, err error) {
+	meta := typeMeta{}
+	if err := yaml.Unmarshal(layer.Data, &meta); err != nil {
+		return nil, nil, nil, fmt.Errorf("parsing cloud config from %s: %v", layer.Source, err)
+	}
+
+	fields = make(map[string]interface{})
+	if err := yaml.Unmarshal(layer.Data, &fields); err != nil {
+		return nil, nil, nil, fmt.Errorf("parsing cloud config from %s: %v", layer.Source, err)
+	}
+	delete(fields, apiVersionField)
+
+	switch meta.APIVersion {
+	case APIVersionV1:
+		if err := yaml.UnmarshalStrict(layer.Data, &ConfigV1{}); err != nil {
+			return nil, nil, nil, fmt.Errorf("parsing cloud config from %s: %v", layer.Source, err)
+		}
+		// encoding/json matches field names case-insensitively, hence the case is checked here.
+		for key := range fields {
+			name, ok := fieldNamesV1[strings.ToLower(key)]
+			if !ok {
+				return nil, nil, nil, fmt.Errorf("parsing cloud config from %s: unknown field %q", layer.Source, key)
+			}
+			if name != key {
+				return nil, nil, nil, fmt.Errorf("parsing cloud config from %s: field %q should be %q", layer.Source, key, name)
+			}
+		}
+		return fields, nil, nil, nil
+	case "":
+		warnings = []string{fmt.Sprintf("cloud config from %s has no apiVersion, which is deprecated; set apiVersion to %q", layer.Source, APIVersionV1)}
+		normalized := make(map[string]interface{})
+		for key, value := range fields {
+			if _, ok := fieldNames[strings.ToLower(key)]; !ok {
+				unknown = append(unknown, key)
+			}
+			normalized[canonicalFieldName(key)] = value
+		}
+		sort.Strings(unknown)
+		for _, key := range unknown {
+			warnings = append(warnings, fmt.Sprintf("unknown field %q in cloud config from %s is ignored", key, layer.Source))
+		}
+		return normalized, unknown, warnings, nil
+	default:
+		return nil, nil, nil, fmt.Errorf("unsupported apiVersion %q of cloud config from %s, must be %q", meta.APIVersion, layer.Source, APIVersionV1)
+	}
+}
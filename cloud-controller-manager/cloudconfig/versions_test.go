@@ -0,0 +1,197 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cloudconfig
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	azureprovider "k8s.io/kubernetes/pkg/cloudprovider/providers/azure"
+)
+
+// fillFields sets all fields of the struct v points to, including fields of embedded and
+// nested structs, to distinct non-zero values.
+func fillFields(v reflect.Value, next *int) {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Field(i)
+		*next++
+		switch field.Kind() {
+		case reflect.Struct:
+			fillFields(field, next)
+		case reflect.Ptr:
+			field.Set(reflect.New(field.Type().Elem()))
+			if field.Elem().Kind() == reflect.Struct {
+				fillFields(field.Elem(), next)
+			} else {
+				fillValue(field.Elem(), *next)
+			}
+		default:
+			fillValue(field, *next)
+		}
+	}
+}
+
+func fillValue(v reflect.Value, n int) {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(fmt.Sprintf("value%d", n))
+	case reflect.Bool:
+		v.SetBool(true)
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		v.SetInt(int64(n))
+	case reflect.Float32, reflect.Float64:
+		v.SetFloat(float64(n) + 0.5)
+	default:
+		panic(fmt.Sprintf("unsupported kind %s", v.Kind()))
+	}
+}
+
+func toFields(t *testing.T, v ...interface{}) map[string]interface{} {
+	fields := make(map[string]interface{})
+	for _, obj := range v {
+		data, err := json.Marshal(obj)
+		assert.NoError(t, err)
+		assert.NoError(t, json.Unmarshal(data, &fields))
+	}
+	return fields
+}
+
+func TestMergeVersions(t *testing.T) {
+	testCases := []struct {
+		desc             string
+		data             string
+		expectedClientID string
+		expectedWarnings []string
+		expectedUnknown  []string
+		expectErr        bool
+	}{
+		{
+			desc: "v1 config should be decoded without warnings",
+			data: `
+apiVersion: cloudprovider.azure.k8s.io/v1
+aadClientId: client
+cloudProviderBackoffRetries: 6
+cloudEnvironment:
+  name: AzureStackHub
+`,
+			expectedClientID: "client",
+		},
+		{
+			desc: "unversioned config should be decoded with warnings",
+			data: `{
+    "aadclientid": "client",
+    "cloudProviderBackoffRetires": 6
+}`,
+			expectedClientID: "client",
+			expectedWarnings: []string{
+				`cloud config from file has no apiVersion, which is deprecated; set apiVersion to "cloudprovider.azure.k8s.io/v1"`,
+				`unknown field "cloudProviderBackoffRetires" in cloud config from file is ignored`,
+			},
+			expectedUnknown: []string{"cloudProviderBackoffRetires"},
+		},
+		{
+			desc:      "misspelled field should be rejected in v1 config",
+			data:      `{"apiVersion": "cloudprovider.azure.k8s.io/v1", "cloudProviderBackoffRetires": 6}`,
+			expectErr: true,
+		},
+		{
+			desc:      "unknown field of cloud environment should be rejected in v1 config",
+			data:      `{"apiVersion": "cloudprovider.azure.k8s.io/v1", "cloudEnvironment": {"resourceManager": "https://management.local/"}}`,
+			expectErr: true,
+		},
+		{
+			desc:      "field in a different case should be rejected in v1 config",
+			data:      `{"apiVersion": "cloudprovider.azure.k8s.io/v1", "aadclientid": "client"}`,
+			expectErr: true,
+		},
+		{
+			desc:      "apiVersion in a different case should be rejected",
+			data:      `{"apiversion": "cloudprovider.azure.k8s.io/v1", "aadClientId": "client"}`,
+			expectErr: true,
+		},
+		{
+			desc:      "duplicated field should be rejected in v1 config",
+			data:      "apiVersion: cloudprovider.azure.k8s.io/v1\naadClientId: a\naadClientId: b\n",
+			expectErr: true,
+		},
+		{
+			desc:      "field of wrong type should be rejected in v1 config",
+			data:      `{"apiVersion": "cloudprovider.azure.k8s.io/v1", "cloudProviderBackoffRetries": "6"}`,
+			expectErr: true,
+		},
+		{
+			desc:      "unsupported apiVersion should be rejected",
+			data:      `{"apiVersion": "cloudprovider.azure.k8s.io/v2"}`,
+			expectErr: true,
+		},
+	}
+
+	for _, test := range testCases {
+		loaded, err := Merge(Layer{Source: SourceFile, Data: []byte(test.data)})
+		if test.expectErr {
+			assert.Error(t, err, test.desc)
+			continue
+		}
+		assert.NoError(t, err, test.desc)
+		assert.Equal(t, test.expectedClientID, loaded.Config.AADClientID, test.desc)
+		assert.Equal(t, test.expectedWarnings, loaded.Warnings, test.desc)
+		assert.Equal(t, test.expectedUnknown, loaded.UnknownFields, test.desc)
+		assert.NotContains(t, loaded.Sources, "apiVersion", test.desc)
+		assert.NotContains(t, string(loaded.Data), "apiVersion", test.desc)
+	}
+}
+
+func TestConvertV1AllFields(t *testing.T) {
+	// Fields added to the provider config fail the test until they are added to ConfigV1
+	// and its conversions.
+	config := &azureprovider.Config{}
+	environment := &EnvironmentConfig{}
+	next := 0
+	fillFields(reflect.ValueOf(config).Elem(), &next)
+	fillFields(reflect.ValueOf(environment).Elem(), &next)
+
+	v1 := ConvertToV1(config, environment)
+	expected := toFields(t, config, environment)
+	expected[apiVersionField] = APIVersionV1
+	assert.Equal(t, expected, toFields(t, v1))
+
+	convertedConfig, convertedEnvironment := ConvertFromV1(v1)
+	assert.Equal(t, config, convertedConfig)
+	assert.Equal(t, environment, convertedEnvironment)
+	// The converted configs don't share pointers.
+	assert.False(t, config.ExcludeMasterFromStandardLB == convertedConfig.ExcludeMasterFromStandardLB)
+	assert.False(t, environment.CloudEnvironment == convertedEnvironment.CloudEnvironment)
+}
+
+func TestConvertV1(t *testing.T) {
+	config := newValidConfig()
+	environment := &EnvironmentConfig{CloudEnvironmentFilePath: "/etc/kubernetes/env.json"}
+
+	v1 := ConvertToV1(config, environment)
+	assert.Equal(t, APIVersionV1, v1.APIVersion)
+	convertedConfig, convertedEnvironment := ConvertFromV1(v1)
+	assert.Equal(t, config, convertedConfig)
+	assert.Equal(t, environment, convertedEnvironment)
+
+	v1 = &ConfigV1{}
+	SetDefaultsV1(v1)
+	assert.Equal(t, APIVersionV1, v1.APIVersion)
+	assert.Equal(t, vmTypeStandard, v1.VMType)
+}
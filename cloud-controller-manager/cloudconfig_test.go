@@ -0,0 +1,93 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/assert"
+
+	"k8s.io/cloud-provider-azure/cloud-controller-manager/cloudconfig"
+)
+
+func newTestFlagSet(cloudConfig string) *pflag.FlagSet {
+	fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	fs.String("cloud-config", cloudConfig, "")
+	return fs
+}
+
+func TestPrepareCloudConfigFromFile(t *testing.T) {
+	path, cleanup := writeTestFile(t, "azure.json", `{"resourceGroup": "rg", "loadBalancerSku": "basic"}`)
+	defer cleanup()
+
+	// The file is read by the Azure cloud provider as is.
+	fs := newTestFlagSet(path)
+	files := &cloudConfigFiles{}
+	assert.NoError(t, prepareCloudConfig(cloudconfig.NewOptions(), fs, files))
+	assert.Equal(t, path, getFlagValue(fs, "cloud-config"))
+	assert.Empty(t, files.paths)
+
+	// Nothing is loaded without the file.
+	fs = newTestFlagSet("")
+	assert.NoError(t, prepareCloudConfig(cloudconfig.NewOptions(), fs, files))
+	assert.Equal(t, "", getFlagValue(fs, "cloud-config"))
+	assert.Empty(t, files.paths)
+}
+
+func TestPrepareCloudConfigWithOverrides(t *testing.T) {
+	path, cleanup := writeTestFile(t, "azure.json", `{"resourceGroup": "rg", "loadBalancerSku": "basic"}`)
+	defer cleanup()
+
+	o := cloudconfig.NewOptions()
+	o.Overrides = []string{"loadBalancerSku=standard"}
+	fs := newTestFlagSet(path)
+	files := &cloudConfigFiles{}
+	assert.NoError(t, prepareCloudConfig(o, fs, files))
+
+	// --cloud-config points to the merged config, which is removed by files.Remove.
+	merged := getFlagValue(fs, "cloud-config")
+	assert.NotEqual(t, path, merged)
+	assert.Equal(t, []string{merged}, files.paths)
+	data, err := ioutil.ReadFile(merged)
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), `"loadBalancerSku":"standard"`)
+	assert.Contains(t, string(data), `"resourceGroup":"rg"`)
+	info, err := os.Stat(merged)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	files.Remove()
+	_, err = os.Stat(merged)
+	assert.True(t, os.IsNotExist(err))
+	assert.Empty(t, files.paths)
+}
+
+func TestPrepareCloudConfigWithInvalidOverride(t *testing.T) {
+	path, cleanup := writeTestFile(t, "azure.json", `{"resourceGroup": "rg"}`)
+	defer cleanup()
+
+	o := cloudconfig.NewOptions()
+	o.Overrides = []string{"resourceGrop=rg2"}
+	fs := newTestFlagSet(path)
+	files := &cloudConfigFiles{}
+	assert.Error(t, prepareCloudConfig(o, fs, files))
+	assert.Equal(t, path, getFlagValue(fs, "cloud-config"))
+	assert.Empty(t, files.paths)
+}
@@ -0,0 +1,200 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/spf13/pflag"
+
+	"k8s.io/client-go/informers"
+	clientset "k8s.io/client-go/kubernetes"
+	restclient "k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	cloudprovider "k8s.io/cloud-provider"
+	"k8s.io/cloud-provider-azure/cloud-controller-manager/capacity"
+	"k8s.io/cloud-provider-azure/cloud-controller-manager/imds"
+	"k8s.io/cloud-provider-azure/cloud-controller-manager/nodeaddress"
+	"k8s.io/cloud-provider-azure/cloud-controller-manager/nodelabel"
+	"k8s.io/cloud-provider-azure/cloud-controller-manager/reconcile"
+	azureprovider "k8s.io/kubernetes/pkg/cloudprovider/providers/azure"
+)
+
+const (
+	// nodeLabelResyncPeriod is the interval at which all nodes are relabeled.
+	nodeLabelResyncPeriod = time.Hour
+	// nodeLabelWorkers is the number of nodes that are allowed to be labeled concurrently.
+	nodeLabelWorkers = 2
+	// capacityRefreshPeriod is the interval at which the capacity metrics are refreshed.
+	capacityRefreshPeriod = 5 * time.Minute
+)
+
+// azureControllerOptions holds the options of controllers which are not shipped with the upstream cloud controller manager.
+type azureControllerOptions struct {
+	EnableNodeLabeling    bool
+	EnableCapacityMetrics bool
+	NodeAddresses         nodeaddress.Options
+}
+
+// AddFlags adds flags for the Azure specific controllers to the specified FlagSet.
+func (o *azureControllerOptions) AddFlags(fs *pflag.FlagSet) {
+	fs.BoolVar(&o.EnableNodeLabeling, "enable-node-labeling", o.EnableNodeLabeling,
+		"Enable the node label controller, which labels nodes with fault domain, update domain, scale set and VM SKU capabilities.")
+	fs.BoolVar(&o.EnableCapacityMetrics, "enable-capacity-metrics", o.EnableCapacityMetrics,
+		"Enable metrics of the load balancing rules, frontend IP configurations and security rule priorities used by the cluster.")
+	o.NodeAddresses.AddFlags(fs)
+}
+
+// azureCloudProviderName is the name under which azureCloud is registered. --cloud-provider=azure
+// is replaced by it, so that the cloud controller manager initializes azureCloud instead.
+const azureCloudProviderName = "azure-with-controllers"
+
+// azureCloud wraps the Azure cloud provider to start the Azure specific controllers together with
+// the controllers of the cloud controller manager, i.e. only on the elected leader, reusing the
+// same Cloud instance, clients and informers. It also reports the node addresses with the node
+// address options, and measures the load balancer and route operations.
+type azureCloud struct {
+	*azureprovider.Cloud
+
+	options       *azureControllerOptions
+	clientBuilder cloudprovider.ControllerClientBuilder
+	stopCh        <-chan struct{}
+
+	// instances is nil if no node address option is set.
+	instances *nodeaddress.Instances
+}
+
+// registerAzureCloud registers azureCloud, which starts the controllers enabled in o. The files
+// written for the cloud config are removed once the Azure cloud provider has read them.
+func registerAzureCloud(o *azureControllerOptions, files *cloudConfigFiles) {
+	cloudprovider.RegisterCloudProvider(azureCloudProviderName, func(config io.Reader) (cloudprovider.Interface, error) {
+		cloud, err := azureprovider.NewCloud(config)
+		files.Remove()
+		if err != nil {
+			return nil, err
+		}
+		az, ok := cloud.(*azureprovider.Cloud)
+		if !ok {
+			return nil, fmt.Errorf("cloud provider %q is not Azure", cloud.ProviderName())
+		}
+		return newAzureCloud(o, az), nil
+	})
+}
+
+// newAzureCloud returns azureCloud wrapping az.
+func newAzureCloud(o *azureControllerOptions, az *azureprovider.Cloud) *azureCloud {
+	cloud := &azureCloud{Cloud: az, options: o}
+	if o.NodeAddresses.Enabled() {
+		var metadata *imds.Client
+		if az.UseInstanceMetadata {
+			metadata = imds.NewClient(imds.MetadataEndpoint, imds.AttestationOptions{})
+		}
+		cloud.instances = nodeaddress.NewInstances(az, metadata, o.NodeAddresses)
+	}
+	return cloud
+}
+
+// useAzureCloud makes the cloud controller manager use azureCloud if the Azure cloud provider is configured.
+func useAzureCloud(fs *pflag.FlagSet) error {
+	if getFlagValue(fs, "cloud-provider") != azureprovider.CloudProviderName {
+		return nil
+	}
+	return fs.Set("cloud-provider", azureCloudProviderName)
+}
+
+// Initialize is called by the cloud controller manager before its controllers are started.
+func (az *azureCloud) Initialize(clientBuilder cloudprovider.ControllerClientBuilder, stop <-chan struct{}) {
+	az.Cloud.Initialize(clientBuilder, stop)
+	az.clientBuilder = clientBuilder
+	az.stopCh = stop
+}
+
+// Instances returns the instances interface, which reports the node addresses with the node
+// address options if any of them is set.
+func (az *azureCloud) Instances() (cloudprovider.Instances, bool) {
+	if az.instances == nil {
+		return az.Cloud.Instances()
+	}
+	return az.instances, true
+}
+
+// LoadBalancer returns the load balancer interface, whose operations are measured.
+func (az *azureCloud) LoadBalancer() (cloudprovider.LoadBalancer, bool) {
+	lb, ok := az.Cloud.LoadBalancer()
+	if !ok {
+		return nil, false
+	}
+	return &reconcile.LoadBalancer{LoadBalancer: lb}, true
+}
+
+// Routes returns the routes interface, whose operations are measured.
+func (az *azureCloud) Routes() (cloudprovider.Routes, bool) {
+	routes, ok := az.Cloud.Routes()
+	if !ok {
+		return nil, false
+	}
+	return &reconcile.Routes{Routes: routes}, true
+}
+
+// SetInformers is called after Initialize, with the informers which are started once all
+// controllers of the cloud controller manager are started.
+func (az *azureCloud) SetInformers(informerFactory informers.SharedInformerFactory) {
+	az.Cloud.SetInformers(informerFactory)
+	startAzureControllers(az.options, az.Cloud, az.clientBuilder, informerFactory, az.stopCh)
+}
+
+// startAzureControllers starts the enabled Azure specific controllers.
+func startAzureControllers(
+	o *azureControllerOptions,
+	az *azureprovider.Cloud,
+	clientBuilder cloudprovider.ControllerClientBuilder,
+	informerFactory informers.SharedInformerFactory,
+	stopCh <-chan struct{}) {
+	if o.EnableNodeLabeling {
+		kubeClient := clientBuilder.ClientOrDie("azure-node-label-controller")
+		controller := nodelabel.NewController(kubeClient, informerFactory.Core().V1().Nodes(), az, nodeLabelResyncPeriod)
+		go controller.Run(nodeLabelWorkers, stopCh)
+	}
+
+	if o.EnableCapacityMetrics {
+		collector := capacity.NewCollector(az, capacityRefreshPeriod)
+		prometheus.MustRegister(collector)
+		go collector.Run(stopCh)
+	}
+}
+
+// newKubeClient returns a Kubernetes client built from the --master and --kubeconfig flags.
+func newKubeClient(fs *pflag.FlagSet, userAgent string) (clientset.Interface, error) {
+	restConfig, err := clientcmd.BuildConfigFromFlags(getFlagValue(fs, "master"), getFlagValue(fs, "kubeconfig"))
+	if err != nil {
+		return nil, err
+	}
+	return clientset.NewForConfig(restclient.AddUserAgent(restConfig, userAgent))
+}
+
+// getFlagValue returns the value of flag name, or an empty string if the flag is not defined.
+func getFlagValue(fs *pflag.FlagSet, name string) string {
+	flag := fs.Lookup(name)
+	if flag == nil {
+		return ""
+	}
+
+	return flag.Value.String()
+}
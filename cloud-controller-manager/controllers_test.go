@@ -0,0 +1,52 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"k8s.io/cloud-provider-azure/cloud-controller-manager/nodeaddress"
+	"k8s.io/cloud-provider-azure/cloud-controller-manager/reconcile"
+	azureprovider "k8s.io/kubernetes/pkg/cloudprovider/providers/azure"
+)
+
+func TestAzureCloudInstances(t *testing.T) {
+	az := &azureprovider.Cloud{}
+
+	instances, ok := newAzureCloud(&azureControllerOptions{}, az).Instances()
+	assert.True(t, ok)
+	assert.Equal(t, az, instances)
+
+	o := &azureControllerOptions{NodeAddresses: nodeaddress.Options{IncludeSecondaryIPs: true}}
+	instances, ok = newAzureCloud(o, az).Instances()
+	assert.True(t, ok)
+	assert.IsType(t, &nodeaddress.Instances{}, instances)
+}
+
+func TestAzureCloudMeasuresOperations(t *testing.T) {
+	az := newAzureCloud(&azureControllerOptions{}, &azureprovider.Cloud{})
+
+	lb, ok := az.LoadBalancer()
+	assert.True(t, ok)
+	assert.IsType(t, &reconcile.LoadBalancer{}, lb)
+
+	routes, ok := az.Routes()
+	assert.True(t, ok)
+	assert.IsType(t, &reconcile.Routes{}, routes)
+}
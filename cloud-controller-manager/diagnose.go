@@ -0,0 +1,111 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/spf13/cobra"
+
+	"k8s.io/cloud-provider-azure/cloud-controller-manager/cloudconfig"
+	"k8s.io/cloud-provider-azure/cloud-controller-manager/diagnose"
+	"k8s.io/kubernetes/pkg/cloudprovider/providers/azure/auth"
+)
+
+// diagnoseOptions holds the options of the diagnose command.
+type diagnoseOptions struct {
+	CloudConfig             string
+	ResourceManagerEndpoint string
+}
+
+// newDiagnoseCommand returns the command which checks the access to the Azure resources in the cloud config.
+func newDiagnoseCommand() *cobra.Command {
+	o := &diagnoseOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "diagnose",
+		Short: "Check the connectivity and permissions to the Azure resources in a cloud config",
+		Long: `Check the connectivity and permissions to the Azure resources in a cloud config. A token is
+acquired with the credentials in the config, and read access to the resource group, virtual
+network, subnet, network security group, route table, primary scale set or availability set,
+load balancers, public IPs and VMs is probed. The RBAC actions missing for the clients of the
+Azure cloud provider are reported. The command exits with a non-zero code if any check fails.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := runDiagnose(o, os.Stdout, os.Stderr); err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+				os.Exit(1)
+			}
+		},
+	}
+
+	fs := cmd.Flags()
+	fs.StringVar(&o.CloudConfig, "cloud-config", o.CloudConfig, "The path to the cloud config file.")
+	fs.StringVar(&o.ResourceManagerEndpoint, "resource-manager-endpoint", o.ResourceManagerEndpoint,
+		"The ARM endpoint to probe, default to the resource manager endpoint of the cloud environment.")
+
+	return cmd
+}
+
+func runDiagnose(o *diagnoseOptions, out, errOut io.Writer) error {
+	if o.CloudConfig == "" {
+		return fmt.Errorf("--cloud-config is required")
+	}
+
+	data, err := ioutil.ReadFile(o.CloudConfig)
+	if err != nil {
+		return err
+	}
+	loaded, err := cloudconfig.Merge(cloudconfig.Layer{Source: cloudconfig.SourceFile, Data: data})
+	if err != nil {
+		return err
+	}
+	for _, warning := range loaded.Warnings {
+		fmt.Fprintf(errOut, "Warning: %s\n", warning)
+	}
+	config := loaded.Config
+	cloudconfig.SetDefaults(config)
+
+	env, err := loaded.GetEnvironment()
+	if err != nil {
+		return err
+	}
+	token, err := auth.GetServicePrincipalToken(&config.AzureAuthConfig, env)
+	if err != nil {
+		return fmt.Errorf("creating token: %v", err)
+	}
+	if err := token.Refresh(); err != nil {
+		return fmt.Errorf("acquiring token from %s: %v", env.ActiveDirectoryEndpoint, err)
+	}
+	fmt.Fprintf(out, "Acquired token from %s\n\n", env.ActiveDirectoryEndpoint)
+
+	endpoint := o.ResourceManagerEndpoint
+	if endpoint == "" {
+		endpoint = env.ResourceManagerEndpoint
+	}
+	results := diagnose.NewDiagnoser(endpoint, autorest.NewBearerAuthorizer(token)).Run(config)
+	if err := diagnose.WriteReport(out, results); err != nil {
+		return err
+	}
+	if diagnose.HasFailures(results) {
+		return fmt.Errorf("some checks failed")
+	}
+	return nil
+}
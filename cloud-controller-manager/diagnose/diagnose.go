@@ -0,0 +1,413 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package diagnose probes read access to the Azure resources configured in the cloud
+// config, and reports the RBAC actions which are missing.
+package diagnose
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest"
+
+	azureprovider "k8s.io/kubernetes/pkg/cloudprovider/providers/azure"
+)
+
+const (
+	computeAPIVersion   = "2018-10-01"
+	networkAPIVersion   = "2017-09-01"
+	resourcesAPIVersion = "2018-05-01"
+	storageAPIVersion   = "2018-07-01"
+	requestTimeout      = 30 * time.Second
+)
+
+// Status is the result of a check.
+type Status string
+
+const (
+	// StatusOK means the resource could be read.
+	StatusOK Status = "OK"
+	// StatusForbidden means the identity isn't allowed to read the resource.
+	StatusForbidden Status = "Forbidden"
+	// StatusNotFound means the resource doesn't exist.
+	StatusNotFound Status = "NotFound"
+	// StatusError means the check failed for other reasons.
+	StatusError Status = "Error"
+	// StatusSkipped means the resource isn't configured.
+	StatusSkipped Status = "Skipped"
+)
+
+// requiredActions maps the client interfaces of the Azure cloud provider to the RBAC
+// actions they need.
+var requiredActions = map[string][]string{
+	"DisksClient": {
+		"Microsoft.Compute/disks/*",
+	},
+	"InterfacesClient": {
+		"Microsoft.Network/networkInterfaces/read",
+		"Microsoft.Network/networkInterfaces/write",
+	},
+	"LoadBalancersClient": {
+		"Microsoft.Network/loadBalancers/read",
+		"Microsoft.Network/loadBalancers/write",
+		"Microsoft.Network/loadBalancers/delete",
+		"Microsoft.Network/loadBalancers/backendAddressPools/join/action",
+	},
+	"PublicIPAddressesClient": {
+		"Microsoft.Network/publicIPAddresses/read",
+		"Microsoft.Network/publicIPAddresses/write",
+		"Microsoft.Network/publicIPAddresses/delete",
+		"Microsoft.Network/publicIPAddresses/join/action",
+	},
+	"RouteTablesClient": {
+		"Microsoft.Network/routeTables/read",
+		"Microsoft.Network/routeTables/write",
+	},
+	"RoutesClient": {
+		"Microsoft.Network/routeTables/routes/read",
+		"Microsoft.Network/routeTables/routes/write",
+		"Microsoft.Network/routeTables/routes/delete",
+	},
+	"SnapshotsClient": {
+		"Microsoft.Compute/snapshots/*",
+	},
+	"StorageAccountClient": {
+		"Microsoft.Storage/storageAccounts/*",
+	},
+	"SecurityGroupsClient": {
+		"Microsoft.Network/networkSecurityGroups/read",
+		"Microsoft.Network/networkSecurityGroups/write",
+		"Microsoft.Network/networkSecurityGroups/join/action",
+	},
+	"SubnetsClient": {
+		"Microsoft.Network/virtualNetworks/subnets/read",
+		"Microsoft.Network/virtualNetworks/subnets/join/action",
+	},
+	"VirtualMachinesClient": {
+		"Microsoft.Compute/virtualMachines/read",
+		"Microsoft.Compute/virtualMachines/write",
+		"Microsoft.Compute/availabilitySets/read",
+	},
+	"VirtualMachineScaleSetsClient": {
+		"Microsoft.Compute/virtualMachineScaleSets/read",
+		"Microsoft.Compute/virtualMachineScaleSets/write",
+	},
+	"VirtualMachineScaleSetVMsClient": {
+		"Microsoft.Compute/virtualMachineScaleSets/virtualMachines/read",
+		"Microsoft.Compute/virtualMachineScaleSets/virtualMachines/write",
+		"Microsoft.Compute/virtualMachineScaleSets/virtualMachines/networkInterfaces/read",
+	},
+	"VirtualMachineSizesClient": {
+		"Microsoft.Compute/locations/vmSizes/read",
+	},
+}
+
+// check is a read probe of one resource.
+type check struct {
+	name string
+	// path is the resource path, empty if the resource isn't configured.
+	path       string
+	apiVersion string
+	// readAction is the RBAC action needed by the probe.
+	readAction string
+	// clients are the client interfaces of the Azure cloud provider which use the resource.
+	clients []string
+}
+
+// Result is the result of a check.
+type Result struct {
+	Check      string
+	ResourceID string
+	Status     Status
+	// MissingActions are the RBAC actions which are known to be missing.
+	MissingActions []string
+	// Clients are the client interfaces of the Azure cloud provider which use the resource.
+	Clients []string
+	Message string
+}
+
+// getChecks returns the checks of the resources configured in the config.
+func getChecks(config *azureprovider.Config) []check {
+	subscription := fmt.Sprintf("/subscriptions/%s", config.SubscriptionID)
+	resourceGroup := fmt.Sprintf("/subscriptions/%s/resourceGroups/%s", config.SubscriptionID, config.ResourceGroup)
+	vnetResourceGroup := resourceGroup
+	if config.VnetResourceGroup != "" {
+		vnetResourceGroup = fmt.Sprintf("/subscriptions/%s/resourceGroups/%s", config.SubscriptionID, config.VnetResourceGroup)
+	}
+	resourcePath := func(parent, format string, names ...string) string {
+		for _, name := range names {
+			if name == "" {
+				return ""
+			}
+		}
+		args := make([]interface{}, len(names))
+		for i, name := range names {
+			args[i] = name
+		}
+		return parent + fmt.Sprintf(format, args...)
+	}
+
+	checks := []check{
+		{
+			name:       "Resource group",
+			path:       resourceGroup,
+			apiVersion: resourcesAPIVersion,
+			readAction: "Microsoft.Resources/subscriptions/resourceGroups/read",
+		},
+		{
+			name:       "Virtual network",
+			path:       resourcePath(vnetResourceGroup, "/providers/Microsoft.Network/virtualNetworks/%s", config.VnetName),
+			apiVersion: networkAPIVersion,
+			readAction: "Microsoft.Network/virtualNetworks/read",
+			clients:    []string{"SubnetsClient"},
+		},
+		{
+			name:       "Subnet",
+			path:       resourcePath(vnetResourceGroup, "/providers/Microsoft.Network/virtualNetworks/%s/subnets/%s", config.VnetName, config.SubnetName),
+			apiVersion: networkAPIVersion,
+			readAction: "Microsoft.Network/virtualNetworks/subnets/read",
+			clients:    []string{"SubnetsClient"},
+		},
+		{
+			name:       "Network security group",
+			path:       resourcePath(resourceGroup, "/providers/Microsoft.Network/networkSecurityGroups/%s", config.SecurityGroupName),
+			apiVersion: networkAPIVersion,
+			readAction: "Microsoft.Network/networkSecurityGroups/read",
+			clients:    []string{"SecurityGroupsClient"},
+		},
+		{
+			name:       "Route table",
+			path:       resourcePath(resourceGroup, "/providers/Microsoft.Network/routeTables/%s", config.RouteTableName),
+			apiVersion: networkAPIVersion,
+			readAction: "Microsoft.Network/routeTables/read",
+			clients:    []string{"RouteTablesClient", "RoutesClient"},
+		},
+		{
+			name:       "Load balancers",
+			path:       resourceGroup + "/providers/Microsoft.Network/loadBalancers",
+			apiVersion: networkAPIVersion,
+			readAction: "Microsoft.Network/loadBalancers/read",
+			clients:    []string{"LoadBalancersClient"},
+		},
+		{
+			name:       "Public IP addresses",
+			path:       resourceGroup + "/providers/Microsoft.Network/publicIPAddresses",
+			apiVersion: networkAPIVersion,
+			readAction: "Microsoft.Network/publicIPAddresses/read",
+			clients:    []string{"PublicIPAddressesClient"},
+		},
+	}
+
+	if strings.EqualFold(config.VMType, "vmss") {
+		checks = append(checks, check{
+			name:       "Primary scale set",
+			path:       resourcePath(resourceGroup, "/providers/Microsoft.Compute/virtualMachineScaleSets/%s", config.PrimaryScaleSetName),
+			apiVersion: computeAPIVersion,
+			readAction: "Microsoft.Compute/virtualMachineScaleSets/read",
+			clients:    []string{"VirtualMachineScaleSetsClient", "VirtualMachineScaleSetVMsClient"},
+		})
+	}
+	checks = append(checks,
+		check{
+			name:       "Primary availability set",
+			path:       resourcePath(resourceGroup, "/providers/Microsoft.Compute/availabilitySets/%s", config.PrimaryAvailabilitySetName),
+			apiVersion: computeAPIVersion,
+			readAction: "Microsoft.Compute/availabilitySets/read",
+			clients:    []string{"VirtualMachinesClient"},
+		},
+		check{
+			name:       "Virtual machines",
+			path:       resourceGroup + "/providers/Microsoft.Compute/virtualMachines",
+			apiVersion: computeAPIVersion,
+			readAction: "Microsoft.Compute/virtualMachines/read",
+			clients:    []string{"VirtualMachinesClient", "InterfacesClient"},
+		},
+		check{
+			name:       "VM sizes",
+			path:       resourcePath(subscription, "/providers/Microsoft.Compute/locations/%s/vmSizes", config.Location),
+			apiVersion: computeAPIVersion,
+			readAction: "Microsoft.Compute/locations/vmSizes/read",
+			clients:    []string{"VirtualMachineSizesClient"},
+		},
+		check{
+			name:       "Managed disks",
+			path:       resourceGroup + "/providers/Microsoft.Compute/disks",
+			apiVersion: computeAPIVersion,
+			readAction: "Microsoft.Compute/disks/read",
+			clients:    []string{"DisksClient"},
+		},
+		check{
+			name:       "Snapshots",
+			path:       resourceGroup + "/providers/Microsoft.Compute/snapshots",
+			apiVersion: computeAPIVersion,
+			readAction: "Microsoft.Compute/snapshots/read",
+			clients:    []string{"SnapshotsClient"},
+		},
+		check{
+			name:       "Storage accounts",
+			path:       resourceGroup + "/providers/Microsoft.Storage/storageAccounts",
+			apiVersion: storageAPIVersion,
+			readAction: "Microsoft.Storage/storageAccounts/read",
+			clients:    []string{"StorageAccountClient"},
+		})
+
+	return checks
+}
+
+// Diagnoser runs the checks against an ARM endpoint.
+type Diagnoser struct {
+	resourceManagerEndpoint string
+	authorizer              autorest.Authorizer
+	httpClient              *http.Client
+}
+
+// NewDiagnoser returns a Diagnoser for the ARM endpoint, e.g. the resource manager
+// endpoint of the cloud environment.
+func NewDiagnoser(resourceManagerEndpoint string, authorizer autorest.Authorizer) *Diagnoser {
+	return &Diagnoser{
+		resourceManagerEndpoint: strings.TrimSuffix(resourceManagerEndpoint, "/"),
+		authorizer:              authorizer,
+		httpClient:              &http.Client{Timeout: requestTimeout},
+	}
+}
+
+// Run runs the checks of the resources configured in the config.
+func (d *Diagnoser) Run(config *azureprovider.Config) []Result {
+	var results []Result
+	for _, c := range getChecks(config) {
+		results = append(results, d.runCheck(c))
+	}
+	return results
+}
+
+// armError is the error returned by ARM, e.g.
+// {"error": {"code": "AuthorizationFailed", "message": "The client ... does not have authorization to perform action ..."}}
+type armError struct {
+	Error struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func (d *Diagnoser) runCheck(c check) Result {
+	result := Result{
+		Check:      c.name,
+		ResourceID: c.path,
+		Clients:    c.clients,
+	}
+	if c.path == "" {
+		result.Status = StatusSkipped
+		result.Message = "not configured"
+		return result
+	}
+
+	req, err := autorest.Prepare(&http.Request{},
+		autorest.AsGet(),
+		autorest.WithBaseURL(d.resourceManagerEndpoint),
+		autorest.WithPath(c.path),
+		autorest.WithQueryParameters(map[string]interface{}{"api-version": c.apiVersion}),
+		d.authorizer.WithAuthorization())
+	if err != nil {
+		result.Status = StatusError
+		result.Message = err.Error()
+		return result
+	}
+
+	resp, err := d.httpClient.Do(req)
+	if err != nil {
+		result.Status = StatusError
+		result.Message = err.Error()
+		return result
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		result.Status = StatusOK
+		return result
+	case http.StatusForbidden:
+		result.Status = StatusForbidden
+		result.MissingActions = []string{c.readAction}
+	case http.StatusNotFound:
+		result.Status = StatusNotFound
+	default:
+		result.Status = StatusError
+	}
+
+	armErr := armError{}
+	if err := json.Unmarshal(body, &armErr); err == nil && armErr.Error.Code != "" {
+		result.Message = fmt.Sprintf("%s: %s", armErr.Error.Code, armErr.Error.Message)
+	} else {
+		result.Message = resp.Status
+	}
+	return result
+}
+
+// HasFailures returns true if any check failed.
+func HasFailures(results []Result) bool {
+	for _, result := range results {
+		if result.Status != StatusOK && result.Status != StatusSkipped {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteReport writes the results as a table, followed by the RBAC actions needed by the
+// clients of the resources which couldn't be read.
+func WriteReport(w io.Writer, results []Result) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "CHECK\tSTATUS\tMISSING ACTIONS\tMESSAGE")
+	var forbiddenClients []string
+	seen := make(map[string]bool)
+	for _, result := range results {
+		missing := strings.Join(result.MissingActions, ",")
+		if missing == "" {
+			missing = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", result.Check, result.Status, missing, result.Message)
+
+		if result.Status != StatusForbidden {
+			continue
+		}
+		for _, client := range result.Clients {
+			if !seen[client] {
+				seen[client] = true
+				forbiddenClients = append(forbiddenClients, client)
+			}
+		}
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if len(forbiddenClients) == 0 {
+		return nil
+	}
+	fmt.Fprintln(w, "\nThe following clients of the Azure cloud provider can't access the resources above. They need these actions:")
+	for _, client := range forbiddenClients {
+		fmt.Fprintf(w, "  %s: %s\n", client, strings.Join(requiredActions[client], ", "))
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package diagnose
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/stretchr/testify/assert"
+
+	azureprovider "k8s.io/kubernetes/pkg/cloudprovider/providers/azure"
+	"k8s.io/kubernetes/pkg/cloudprovider/providers/azure/auth"
+)
+
+// newFakeARMServer returns a stand-in ARM endpoint, which denies access to route tables
+// and storage accounts, and has no network security groups.
+func newFakeARMServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.NotEmpty(t, r.URL.Query().Get("api-version"))
+
+		switch {
+		case strings.Contains(r.URL.Path, "/routeTables/"):
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"error":{"code":"AuthorizationFailed","message":"The client does not have authorization to perform action 'Microsoft.Network/routeTables/read'"}}`)
+		case strings.HasSuffix(r.URL.Path, "/storageAccounts"):
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"error":{"code":"AuthorizationFailed","message":"The client does not have authorization to perform action 'Microsoft.Storage/storageAccounts/read'"}}`)
+		case strings.Contains(r.URL.Path, "/networkSecurityGroups/"):
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"error":{"code":"ResourceNotFound","message":"The Resource 'Microsoft.Network/networkSecurityGroups/nsg' was not found."}}`)
+		case strings.Contains(r.URL.Path, "/virtualMachineScaleSets/"):
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			fmt.Fprint(w, `{}`)
+		}
+	}))
+}
+
+func newTestConfig() *azureprovider.Config {
+	return &azureprovider.Config{
+		AzureAuthConfig:   auth.AzureAuthConfig{SubscriptionID: "sub"},
+		ResourceGroup:     "rg",
+		Location:          "westus",
+		VnetName:          "vnet",
+		VnetResourceGroup: "vnet-rg",
+		SubnetName:        "subnet",
+		SecurityGroupName: "nsg",
+		RouteTableName:    "rt",
+		VMType:            "standard",
+	}
+}
+
+func TestRun(t *testing.T) {
+	server := newFakeARMServer(t)
+	defer server.Close()
+
+	vmssConfig := newTestConfig()
+	vmssConfig.VMType = "vmss"
+	vmssConfig.PrimaryScaleSetName = "ss"
+
+	testCases := []struct {
+		desc     string
+		config   *azureprovider.Config
+		expected map[string]Status
+	}{
+		{
+			desc:   "checks of standard config should be run",
+			config: newTestConfig(),
+			expected: map[string]Status{
+				"Resource group":           StatusOK,
+				"Virtual network":          StatusOK,
+				"Subnet":                   StatusOK,
+				"Network security group":   StatusNotFound,
+				"Route table":              StatusForbidden,
+				"Load balancers":           StatusOK,
+				"Public IP addresses":      StatusOK,
+				"Primary availability set": StatusSkipped,
+				"Virtual machines":         StatusOK,
+				"VM sizes":                 StatusOK,
+				"Managed disks":            StatusOK,
+				"Snapshots":                StatusOK,
+				"Storage accounts":         StatusForbidden,
+			},
+		},
+		{
+			desc:   "primary scale set should be checked for vmss config",
+			config: vmssConfig,
+			expected: map[string]Status{
+				"Resource group":           StatusOK,
+				"Virtual network":          StatusOK,
+				"Subnet":                   StatusOK,
+				"Network security group":   StatusNotFound,
+				"Route table":              StatusForbidden,
+				"Load balancers":           StatusOK,
+				"Public IP addresses":      StatusOK,
+				"Primary scale set":        StatusError,
+				"Primary availability set": StatusSkipped,
+				"Virtual machines":         StatusOK,
+				"VM sizes":                 StatusOK,
+				"Managed disks":            StatusOK,
+				"Snapshots":                StatusOK,
+				"Storage accounts":         StatusForbidden,
+			},
+		},
+	}
+
+	for _, test := range testCases {
+		results := NewDiagnoser(server.URL+"/", autorest.NullAuthorizer{}).Run(test.config)
+		statuses := make(map[string]Status)
+		for _, result := range results {
+			statuses[result.Check] = result.Status
+		}
+		assert.Equal(t, test.expected, statuses, test.desc)
+		assert.True(t, HasFailures(results), test.desc)
+	}
+}
+
+func TestRunResult(t *testing.T) {
+	server := newFakeARMServer(t)
+	defer server.Close()
+
+	results := NewDiagnoser(server.URL, autorest.NullAuthorizer{}).Run(newTestConfig())
+	byCheck := make(map[string]Result)
+	for _, result := range results {
+		byCheck[result.Check] = result
+	}
+
+	subnet := byCheck["Subnet"]
+	assert.Equal(t, "/subscriptions/sub/resourceGroups/vnet-rg/providers/Microsoft.Network/virtualNetworks/vnet/subnets/subnet", subnet.ResourceID)
+
+	routeTable := byCheck["Route table"]
+	assert.Equal(t, []string{"Microsoft.Network/routeTables/read"}, routeTable.MissingActions)
+	assert.Equal(t, []string{"RouteTablesClient", "RoutesClient"}, routeTable.Clients)
+	assert.Contains(t, routeTable.Message, "AuthorizationFailed")
+
+	buf := &bytes.Buffer{}
+	assert.NoError(t, WriteReport(buf, results))
+	report := buf.String()
+	assert.Contains(t, report, "Microsoft.Network/routeTables/read")
+	assert.Contains(t, report, "RoutesClient: Microsoft.Network/routeTables/routes/read")
+	assert.Contains(t, report, "StorageAccountClient: Microsoft.Storage/storageAccounts/*")
+	assert.NotContains(t, report, "SecurityGroupsClient:")
+
+	vmSizes := byCheck["VM sizes"]
+	assert.Equal(t, "/subscriptions/sub/providers/Microsoft.Compute/locations/westus/vmSizes", vmSizes.ResourceID)
+	assert.Equal(t, []string{"VirtualMachineSizesClient"}, vmSizes.Clients)
+}
+
+func TestHasFailures(t *testing.T) {
+	assert.False(t, HasFailures([]Result{{Status: StatusOK}, {Status: StatusSkipped}}))
+	assert.True(t, HasFailures([]Result{{Status: StatusOK}, {Status: StatusNotFound}}))
+}
+
+func TestRequiredActions(t *testing.T) {
+	// All clients referenced by checks should have their required actions.
+	for _, c := range getChecks(&azureprovider.Config{VMType: "vmss"}) {
+		for _, client := range c.clients {
+			assert.NotEmpty(t, requiredActions[client], client)
+		}
+	}
+}
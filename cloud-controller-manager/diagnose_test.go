@@ -0,0 +1,129 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newFakeAzureServer returns a stand-in for both Azure Active Directory and ARM, which issues
+// tokens for any tenant and returns empty resources.
+func newFakeAzureServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/oauth2/token") {
+			fmt.Fprint(w, `{"access_token": "token", "token_type": "Bearer", "expires_in": "3600", "expires_on": "4102444800", "not_before": "0", "resource": "resource"}`)
+			return
+		}
+		assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+		fmt.Fprint(w, `{}`)
+	}))
+}
+
+// newTestCloudConfig returns a versioned cloud config whose cloud environment points to the server.
+func newTestCloudConfig(serverURL string) string {
+	return fmt.Sprintf(`{
+		"apiVersion": "cloudprovider.azure.k8s.io/v1",
+		"tenantId": "tenant",
+		"subscriptionId": "sub",
+		"aadClientId": "client",
+		"aadClientSecret": "secret",
+		"resourceGroup": "rg",
+		"location": "westus2",
+		"vnetName": "vnet",
+		"subnetName": "subnet",
+		"securityGroupName": "nsg",
+		"cloudEnvironment": {
+			"name": "AzureTestCloud",
+			"resourceManagerEndpoint": "%[1]s/",
+			"activeDirectoryEndpoint": "%[1]s/",
+			"serviceManagementEndpoint": "%[1]s/",
+			"storageEndpointSuffix": "core.test",
+			"resourceManagerVMDNSSuffix": "cloudapp.test"
+		}
+	}`, serverURL)
+}
+
+func TestRunDiagnose(t *testing.T) {
+	server := newFakeAzureServer(t)
+	defer server.Close()
+
+	path, cleanup := writeTestFile(t, "azure.json", newTestCloudConfig(server.URL))
+	defer cleanup()
+
+	out, errOut := &bytes.Buffer{}, &bytes.Buffer{}
+	assert.NoError(t, runDiagnose(&diagnoseOptions{CloudConfig: path}, out, errOut))
+	assert.Empty(t, errOut.String())
+	assert.Contains(t, out.String(), fmt.Sprintf("Acquired token from %s/\n", server.URL))
+	assert.Contains(t, out.String(), "Resource group")
+}
+
+func TestRunDiagnoseErrors(t *testing.T) {
+	testCases := []struct {
+		desc             string
+		config           string
+		expectedErr      string
+		expectedWarnings []string
+	}{
+		{
+			desc:        "unparsable config should fail",
+			config:      `{"apiVersion": "cloudprovider.azure.k8s.io/v1", "resourceGrop": "rg"}`,
+			expectedErr: `unknown field "resourceGrop"`,
+		},
+		{
+			desc:        "config without credentials should fail before probing",
+			config:      `{"tenantId": "tenant", "subscriptionId": "sub", "aadClientId": "client"}`,
+			expectedErr: "creating token",
+			expectedWarnings: []string{
+				"has no apiVersion",
+			},
+		},
+		{
+			desc:        "unknown fields of unversioned config should be warned about",
+			config:      `{"tenantId": "tenant", "subscriptionId": "sub", "aadClientId": "client", "resourceGrop": "rg"}`,
+			expectedErr: "creating token",
+			expectedWarnings: []string{
+				"has no apiVersion",
+				`Warning: unknown field "resourceGrop" in cloud config from file is ignored`,
+			},
+		},
+	}
+
+	for _, test := range testCases {
+		path, cleanup := writeTestFile(t, "azure.json", test.config)
+		out, errOut := &bytes.Buffer{}, &bytes.Buffer{}
+
+		err := runDiagnose(&diagnoseOptions{CloudConfig: path}, out, errOut)
+		if assert.Error(t, err, test.desc) {
+			assert.Contains(t, err.Error(), test.expectedErr, test.desc)
+		}
+		assert.Empty(t, out.String(), test.desc)
+		for _, warning := range test.expectedWarnings {
+			assert.Contains(t, errOut.String(), warning, test.desc)
+		}
+
+		cleanup()
+	}
+
+	assert.EqualError(t, runDiagnose(&diagnoseOptions{}, &bytes.Buffer{}, &bytes.Buffer{}), "--cloud-config is required")
+}
@@ -0,0 +1,284 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package imds
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rsa"
+	_ "crypto/sha1" // register hash functions used by PKCS#7 digests
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+const (
+	defaultAttestationDNSName = "metadata.azure.com"
+	attestedTimeFormat        = "01/02/06 15:04:05 -0700"
+	attestedEncodingPKCS7     = "pkcs7"
+)
+
+var (
+	oidData          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidSignedData    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+
+	// digestAlgorithms maps the OIDs of digest algorithms to hash functions. Some signers,
+	// including instance metadata service, set the OID of the signature algorithm instead.
+	digestAlgorithms = map[string]crypto.Hash{
+		"1.3.14.3.2.26":          crypto.SHA1,
+		"2.16.840.1.101.3.4.2.1": crypto.SHA256,
+		"2.16.840.1.101.3.4.2.2": crypto.SHA384,
+		"2.16.840.1.101.3.4.2.3": crypto.SHA512,
+		"1.2.840.113549.1.1.5":   crypto.SHA1,
+		"1.2.840.113549.1.1.11":  crypto.SHA256,
+		"1.2.840.113549.1.1.12":  crypto.SHA384,
+		"1.2.840.113549.1.1.13":  crypto.SHA512,
+	}
+)
+
+// The following types are the subset of PKCS#7 (RFC 2315) needed for verifying the attested data.
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+}
+
+type signedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	ContentInfo      contentInfo
+	Certificates     asn1.RawValue `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue `asn1:"optional,tag:1"`
+	SignerInfos      []signerInfo  `asn1:"set"`
+}
+
+type issuerAndSerialNumber struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+type signerInfo struct {
+	Version                   int
+	IssuerAndSerialNumber     issuerAndSerialNumber
+	DigestAlgorithm           pkix.AlgorithmIdentifier
+	AuthenticatedAttributes   asn1.RawValue `asn1:"optional,tag:0"`
+	DigestEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedDigest           []byte
+	UnauthenticatedAttributes asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+type attribute struct {
+	Type  asn1.ObjectIdentifier
+	Value asn1.RawValue `asn1:"set"`
+}
+
+// VerifyAttestedData verifies the PKCS#7 signature of the attested data, its
+// nonce and validity period, and returns the signed document.
+func VerifyAttestedData(attested *AttestedData, nonce string, opts AttestationOptions, now time.Time) (*AttestedDocument, error) {
+	if attested.Encoding != attestedEncodingPKCS7 {
+		return nil, fmt.Errorf("unsupported attested data encoding %q", attested.Encoding)
+	}
+
+	der, err := base64.StdEncoding.DecodeString(attested.Signature)
+	if err != nil {
+		return nil, fmt.Errorf("decoding attested data signature: %v", err)
+	}
+
+	content, err := verifyPKCS7(der, opts, now)
+	if err != nil {
+		return nil, err
+	}
+
+	doc := AttestedDocument{}
+	if err := json.Unmarshal(content, &doc); err != nil {
+		return nil, fmt.Errorf("decoding attested document: %v", err)
+	}
+
+	if doc.Nonce != nonce {
+		return nil, fmt.Errorf("nonce of attested document %q doesn't match %q", doc.Nonce, nonce)
+	}
+	if doc.TimeStamp != nil && doc.TimeStamp.ExpiresOn != "" {
+		expiresOn, err := time.Parse(attestedTimeFormat, doc.TimeStamp.ExpiresOn)
+		if err != nil {
+			return nil, fmt.Errorf("parsing expiresOn of attested document: %v", err)
+		}
+		if now.After(expiresOn) {
+			return nil, fmt.Errorf("attested document has expired on %s", doc.TimeStamp.ExpiresOn)
+		}
+	}
+
+	return &doc, nil
+}
+
+// verifyPKCS7 verifies the signature of the PKCS#7 signed data and the certificate
+// chain of its signer, and returns the signed content.
+func verifyPKCS7(der []byte, opts AttestationOptions, now time.Time) ([]byte, error) {
+	content, signerCert, certs, err := verifySignedData(der)
+	if err != nil {
+		return nil, err
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range opts.Intermediates {
+		intermediates.AddCert(cert)
+	}
+	for _, cert := range certs {
+		if cert != signerCert {
+			intermediates.AddCert(cert)
+		}
+	}
+	dnsName := opts.DNSName
+	if dnsName == "" {
+		dnsName = defaultAttestationDNSName
+	}
+	_, err = signerCert.Verify(x509.VerifyOptions{
+		DNSName:       dnsName,
+		Roots:         opts.Roots,
+		Intermediates: intermediates,
+		CurrentTime:   now,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("verifying attested data certificate: %v", err)
+	}
+
+	return content, nil
+}
+
+// verifySignedData verifies the signature of the PKCS#7 signed data, and returns the
+// signed content, the signer certificate and all certificates included in the data.
+// The certificate chain of the signer isn't verified.
+func verifySignedData(der []byte) ([]byte, *x509.Certificate, []*x509.Certificate, error) {
+	outer := contentInfo{}
+	if _, err := asn1.Unmarshal(der, &outer); err != nil {
+		return nil, nil, nil, fmt.Errorf("parsing PKCS#7: %v", err)
+	}
+	if !outer.ContentType.Equal(oidSignedData) {
+		return nil, nil, nil, fmt.Errorf("PKCS#7 content type %v is not signed data", outer.ContentType)
+	}
+
+	sd := signedData{}
+	if _, err := asn1.Unmarshal(outer.Content.Bytes, &sd); err != nil {
+		return nil, nil, nil, fmt.Errorf("parsing PKCS#7 signed data: %v", err)
+	}
+	if !sd.ContentInfo.ContentType.Equal(oidData) {
+		return nil, nil, nil, fmt.Errorf("PKCS#7 signed content type %v is not data", sd.ContentInfo.ContentType)
+	}
+
+	var content []byte
+	if _, err := asn1.Unmarshal(sd.ContentInfo.Content.Bytes, &content); err != nil {
+		return nil, nil, nil, fmt.Errorf("parsing PKCS#7 content: %v", err)
+	}
+
+	certs, err := x509.ParseCertificates(sd.Certificates.Bytes)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("parsing PKCS#7 certificates: %v", err)
+	}
+	if len(sd.SignerInfos) != 1 {
+		return nil, nil, nil, fmt.Errorf("expected exactly one PKCS#7 signer, got %d", len(sd.SignerInfos))
+	}
+	signer := sd.SignerInfos[0]
+
+	var signerCert *x509.Certificate
+	for _, cert := range certs {
+		if cert.SerialNumber.Cmp(signer.IssuerAndSerialNumber.SerialNumber) == 0 &&
+			bytes.Equal(cert.RawIssuer, signer.IssuerAndSerialNumber.Issuer.FullBytes) {
+			signerCert = cert
+			break
+		}
+	}
+	if signerCert == nil {
+		return nil, nil, nil, fmt.Errorf("PKCS#7 signer certificate not found")
+	}
+
+	if err := verifySignerInfo(&signer, signerCert, content); err != nil {
+		return nil, nil, nil, err
+	}
+
+	return content, signerCert, certs, nil
+}
+
+// verifySignerInfo verifies the signature of signer over the content. If there are
+// authenticated attributes, the signature is over the attributes, which include the
+// digest of the content.
+func verifySignerInfo(signer *signerInfo, cert *x509.Certificate, content []byte) error {
+	hash, ok := digestAlgorithms[signer.DigestAlgorithm.Algorithm.String()]
+	if !ok {
+		return fmt.Errorf("unsupported PKCS#7 digest algorithm %v", signer.DigestAlgorithm.Algorithm)
+	}
+
+	signed := content
+	if len(signer.AuthenticatedAttributes.FullBytes) > 0 {
+		h := hash.New()
+		h.Write(content)
+		digest, err := getMessageDigest(signer.AuthenticatedAttributes.Bytes)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(h.Sum(nil), digest) {
+			return fmt.Errorf("PKCS#7 message digest doesn't match the content")
+		}
+
+		// The signature is over the DER encoding of the attributes as SET OF, rather
+		// than the IMPLICIT [0] tag used in signer info.
+		signed = append([]byte{0x31}, signer.AuthenticatedAttributes.FullBytes[1:]...)
+	}
+
+	h := hash.New()
+	h.Write(signed)
+	hashed := h.Sum(nil)
+
+	pub, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("unsupported PKCS#7 signer public key %T", cert.PublicKey)
+	}
+	if err := rsa.VerifyPKCS1v15(pub, hash, hashed, signer.EncryptedDigest); err != nil {
+		return fmt.Errorf("verifying PKCS#7 signature: %v", err)
+	}
+
+	return nil
+}
+
+// getMessageDigest returns the message digest from the DER encoded attributes.
+func getMessageDigest(attributes []byte) ([]byte, error) {
+	for rest := attributes; len(rest) > 0; {
+		attr := attribute{}
+		var err error
+		rest, err = asn1.Unmarshal(rest, &attr)
+		if err != nil {
+			return nil, fmt.Errorf("parsing PKCS#7 authenticated attributes: %v", err)
+		}
+		if !attr.Type.Equal(oidMessageDigest) {
+			continue
+		}
+
+		var digest []byte
+		if _, err := asn1.Unmarshal(attr.Value.Bytes, &digest); err != nil {
+			return nil, fmt.Errorf("parsing PKCS#7 message digest: %v", err)
+		}
+		return digest, nil
+	}
+
+	return nil, fmt.Errorf("PKCS#7 message digest attribute not found")
+}
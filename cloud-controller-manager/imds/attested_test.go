@@ -0,0 +1,278 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package imds
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testNow = time.Date(2019, 4, 1, 10, 0, 0, 0, time.UTC)
+
+type testCertificate struct {
+	cert *x509.Certificate
+	key  *rsa.PrivateKey
+}
+
+func newTestCertificate(t *testing.T, template *x509.Certificate, parent *testCertificate) *testCertificate {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+
+	parentCert, parentKey := template, key
+	if parent != nil {
+		parentCert, parentKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parentCert, &key.PublicKey, parentKey)
+	assert.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.NoError(t, err)
+
+	return &testCertificate{cert: cert, key: key}
+}
+
+func newTestCertificates(t *testing.T) (*testCertificate, *testCertificate) {
+	root := newTestCertificate(t, &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test Root CA"},
+		NotBefore:             testNow.Add(-time.Hour),
+		NotAfter:              testNow.Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil)
+	leaf := newTestCertificate(t, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "metadata.azure.com"},
+		DNSNames:     []string{"metadata.azure.com"},
+		NotBefore:    testNow.Add(-time.Hour),
+		NotAfter:     testNow.Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}, root)
+
+	return root, leaf
+}
+
+// newTestAttestedData signs content with the leaf certificate as PKCS#7 signed data.
+func newTestAttestedData(t *testing.T, content []byte, leaf *testCertificate, withAttributes bool) *AttestedData {
+	contentDigest := sha256.Sum256(content)
+	si := signerInfo{
+		Version: 1,
+		IssuerAndSerialNumber: issuerAndSerialNumber{
+			Issuer:       asn1.RawValue{FullBytes: leaf.cert.RawIssuer},
+			SerialNumber: leaf.cert.SerialNumber,
+		},
+		DigestAlgorithm:           pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}},
+		DigestEncryptionAlgorithm: pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}},
+	}
+
+	signed := content
+	if withAttributes {
+		digestDER, err := asn1.Marshal(contentDigest[:])
+		assert.NoError(t, err)
+		attrDER, err := asn1.Marshal(attribute{
+			Type:  oidMessageDigest,
+			Value: asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: digestDER},
+		})
+		assert.NoError(t, err)
+		setDER, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: attrDER})
+		assert.NoError(t, err)
+
+		signed = setDER
+		si.AuthenticatedAttributes = asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: attrDER}
+	}
+
+	hashed := sha256.Sum256(signed)
+	signature, err := rsa.SignPKCS1v15(rand.Reader, leaf.key, crypto.SHA256, hashed[:])
+	assert.NoError(t, err)
+	si.EncryptedDigest = signature
+
+	contentDER, err := asn1.Marshal(content)
+	assert.NoError(t, err)
+	sd := signedData{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{si.DigestAlgorithm},
+		ContentInfo: contentInfo{
+			ContentType: oidData,
+			Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: contentDER},
+		},
+		Certificates: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: leaf.cert.Raw},
+		SignerInfos:  []signerInfo{si},
+	}
+	sdDER, err := asn1.Marshal(sd)
+	assert.NoError(t, err)
+
+	der, err := asn1.Marshal(contentInfo{
+		ContentType: oidSignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: sdDER},
+	})
+	assert.NoError(t, err)
+
+	return &AttestedData{
+		Encoding:  attestedEncodingPKCS7,
+		Signature: base64.StdEncoding.EncodeToString(der),
+	}
+}
+
+func TestVerifyAttestedData(t *testing.T) {
+	root, leaf := newTestCertificates(t)
+	_, otherLeaf := newTestCertificates(t)
+	roots := x509.NewCertPool()
+	roots.AddCert(root.cert)
+
+	content := []byte(`{"nonce":"1234567890","plan":{"name":"","product":"","publisher":""},"timeStamp":{"createdOn":"04/01/19 04:00:00 -0000","expiresOn":"04/01/19 16:00:00 -0000"},"vmId":"02aab8a4-74ef-476e-8182-f6d2ba4166a6"}`)
+	expected := &AttestedDocument{
+		Nonce: "1234567890",
+		Plan:  &Plan{},
+		TimeStamp: &AttestedTimeStamp{
+			CreatedOn: "04/01/19 04:00:00 -0000",
+			ExpiresOn: "04/01/19 16:00:00 -0000",
+		},
+		VMID: "02aab8a4-74ef-476e-8182-f6d2ba4166a6",
+	}
+
+	testCases := []struct {
+		desc      string
+		attested  *AttestedData
+		nonce     string
+		now       time.Time
+		expectErr bool
+	}{
+		{
+			desc:     "signature without authenticated attributes should be verified",
+			attested: newTestAttestedData(t, content, leaf, false),
+			nonce:    "1234567890",
+			now:      testNow,
+		},
+		{
+			desc:     "signature with authenticated attributes should be verified",
+			attested: newTestAttestedData(t, content, leaf, true),
+			nonce:    "1234567890",
+			now:      testNow,
+		},
+		{
+			desc:      "error should be returned for mismatched nonce",
+			attested:  newTestAttestedData(t, content, leaf, true),
+			nonce:     "0987654321",
+			now:       testNow,
+			expectErr: true,
+		},
+		{
+			desc:      "error should be returned for expired document",
+			attested:  newTestAttestedData(t, content, leaf, false),
+			nonce:     "1234567890",
+			now:       testNow.Add(7 * time.Hour),
+			expectErr: true,
+		},
+		{
+			desc:      "error should be returned for untrusted signer",
+			attested:  newTestAttestedData(t, content, otherLeaf, false),
+			nonce:     "1234567890",
+			now:       testNow,
+			expectErr: true,
+		},
+		{
+			desc:      "error should be returned for unsupported encoding",
+			attested:  &AttestedData{Encoding: "cms"},
+			nonce:     "1234567890",
+			now:       testNow,
+			expectErr: true,
+		},
+	}
+
+	for _, test := range testCases {
+		doc, err := VerifyAttestedData(test.attested, test.nonce, AttestationOptions{Roots: roots}, test.now)
+		if test.expectErr {
+			assert.Error(t, err, test.desc)
+			continue
+		}
+		assert.NoError(t, err, test.desc)
+		assert.Equal(t, expected, doc, test.desc)
+	}
+}
+
+func TestVerifyAttestedDataTampered(t *testing.T) {
+	root, leaf := newTestCertificates(t)
+	roots := x509.NewCertPool()
+	roots.AddCert(root.cert)
+
+	attested := newTestAttestedData(t, []byte(`{"nonce":"1","vmId":"vm"}`), leaf, false)
+	der, err := base64.StdEncoding.DecodeString(attested.Signature)
+	assert.NoError(t, err)
+	// Replace the VM ID in the signed content.
+	i := bytes.Index(der, []byte(`vm"`))
+	if !assert.True(t, i >= 0) {
+		return
+	}
+	der[i] = 'x'
+	attested.Signature = base64.StdEncoding.EncodeToString(der)
+
+	_, err = VerifyAttestedData(attested, "1", AttestationOptions{Roots: roots}, testNow)
+	assert.Error(t, err)
+}
+
+// TestVerifyAttestedDataFixture verifies the sample attested data published in the instance
+// metadata service documentation (https://docs.microsoft.com/en-us/azure/virtual-machines/linux/instance-metadata-service#attested-data).
+// It is signed by a self-signed certificate of testsubdomain.metadata.azure.com, which is
+// included in the data and is its whole signing chain.
+func TestVerifyAttestedDataFixture(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/attested.json")
+	assert.NoError(t, err)
+	attested := AttestedData{}
+	assert.NoError(t, json.Unmarshal(data, &attested))
+	der, err := base64.StdEncoding.DecodeString(attested.Signature)
+	assert.NoError(t, err)
+
+	content, signerCert, certs, err := verifySignedData(der)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"nonce":"1234566766","plan":{"name":"","product":"","publisher":""},"timeStamp":{"createdOn":"11/20/18 22:07:39 -0000","expiresOn":"11/20/18 22:08:24 -0000"},"vmId":""}`, string(content))
+	assert.Equal(t, "testsubdomain.metadata.azure.com", signerCert.Subject.CommonName)
+	assert.Equal(t, []*x509.Certificate{signerCert}, certs)
+
+	// The signature should not be verified if the content is tampered.
+	tampered := bytes.Replace(der, []byte("1234566766"), []byte("1234566767"), 1)
+	_, _, _, err = verifySignedData(tampered)
+	assert.Error(t, err)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(signerCert)
+	opts := AttestationOptions{Roots: roots, DNSName: "testsubdomain.metadata.azure.com"}
+	now := time.Date(2018, 11, 20, 22, 8, 0, 0, time.UTC)
+
+	// The sample certificate only has a common name, and the DNS name is only matched against
+	// subject alternative names, as in the certificates of metadata.azure.com.
+	_, err = VerifyAttestedData(&attested, "1234566766", opts, now)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "Common Name")
+
+	// The chain should not be trusted without the signing certificate.
+	_, err = VerifyAttestedData(&attested, "1234566766", AttestationOptions{Roots: x509.NewCertPool(), DNSName: opts.DNSName}, now)
+	assert.Error(t, err)
+}
@@ -0,0 +1,237 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package imds
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"k8s.io/klog"
+)
+
+const (
+	// MetadataEndpoint is the endpoint of Azure instance metadata service.
+	MetadataEndpoint = "http://169.254.169.254"
+
+	instancePath         = "/metadata/instance"
+	attestedDocumentPath = "/metadata/attested/document"
+
+	// fallbackAPIVersion is used when the supported API versions can't be negotiated.
+	fallbackAPIVersion = "2017-12-01"
+	// minAttestedAPIVersion is the oldest API version which serves attested data.
+	minAttestedAPIVersion = "2018-10-01"
+	requestTimeout        = 10 * time.Second
+)
+
+// supportedAPIVersions are the API versions known by the client, ordered from newest to oldest.
+var supportedAPIVersions = []string{
+	"2019-03-11",
+	"2019-02-01",
+	"2018-10-01",
+	"2018-04-02",
+	"2018-02-01",
+	"2017-12-01",
+	"2017-08-01",
+	"2017-04-02",
+}
+
+// Client knows how to query Azure instance metadata service. The newest API
+// version supported by both the client and the service is used for all requests.
+type Client struct {
+	endpoint   string
+	httpClient *http.Client
+
+	// attestation holds the options for verifying attested data.
+	attestation AttestationOptions
+
+	apiVersionLock sync.Mutex
+	apiVersion     string
+}
+
+// NewClient returns a new instance metadata client for the endpoint, e.g. MetadataEndpoint.
+func NewClient(endpoint string, attestation AttestationOptions) *Client {
+	return &Client{
+		endpoint:    endpoint,
+		httpClient:  &http.Client{Timeout: requestTimeout},
+		attestation: attestation,
+	}
+}
+
+// AttestationOptions holds the options for verifying the signature of attested data.
+type AttestationOptions struct {
+	// Roots are the trusted root certificates. System roots are used if nil.
+	Roots *x509.CertPool
+	// Intermediates are the intermediate certificates which are not included in the attested data.
+	// The attested data only includes the signing certificate of metadata.azure.com. Its issuing
+	// CA is listed in the caIssuers URL of its Authority Information Access extension, and in the
+	// Azure Certificate Authority details (https://docs.microsoft.com/en-us/azure/security/fundamentals/azure-ca-details).
+	// The intermediates should be downloaded from there ahead of time, since the VM may not be
+	// able to reach the URL, and they change when Azure rotates its CAs. Verification fails
+	// unless the system roots or Roots already chain to the signing certificate.
+	Intermediates []*x509.Certificate
+	// DNSName is the expected DNS name of the signing certificate, default to "metadata.azure.com".
+	DNSName string
+}
+
+// errorResponse is returned by instance metadata service for bad requests, e.g.
+// {"error": "Bad request. api-version is invalid or was not specified in the request.", "newest-versions": ["2018-10-01", "2018-04-02", "2018-02-01"]}
+type errorResponse struct {
+	Error          string   `json:"error"`
+	NewestVersions []string `json:"newest-versions"`
+}
+
+// GetAPIVersion returns the negotiated API version.
+func (c *Client) GetAPIVersion() (string, error) {
+	c.apiVersionLock.Lock()
+	defer c.apiVersionLock.Unlock()
+
+	if c.apiVersion != "" {
+		return c.apiVersion, nil
+	}
+
+	// Instance metadata service returns its newest versions if api-version is not specified.
+	resp, data, err := c.get(instancePath, "", nil)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusBadRequest {
+		return "", fmt.Errorf("failure of negotiating instance metadata API version with response %q", resp.Status)
+	}
+
+	errResp := errorResponse{}
+	if err := json.Unmarshal(data, &errResp); err != nil || len(errResp.NewestVersions) == 0 {
+		klog.Warningf("Failed to get newest versions of instance metadata service, fall back to %s", fallbackAPIVersion)
+		c.apiVersion = fallbackAPIVersion
+		return c.apiVersion, nil
+	}
+
+	c.apiVersion = negotiateAPIVersion(errResp.NewestVersions)
+	klog.V(2).Infof("Using instance metadata API version %s", c.apiVersion)
+	return c.apiVersion, nil
+}
+
+// negotiateAPIVersion returns the newest supported API version which is not
+// newer than the newest version of the service, or the newest version of the
+// service if it is older than all supported versions. API versions are dates, so
+// they are compared as strings.
+func negotiateAPIVersion(serviceVersions []string) string {
+	newest := ""
+	for _, version := range serviceVersions {
+		if version > newest {
+			newest = version
+		}
+	}
+
+	for _, version := range supportedAPIVersions {
+		if version <= newest {
+			return version
+		}
+	}
+
+	return newest
+}
+
+// GetInstanceMetadata gets the compute and network metadata of the VM.
+func (c *Client) GetInstanceMetadata() (*InstanceMetadata, error) {
+	data, err := c.getWithAPIVersion(instancePath, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata := InstanceMetadata{}
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		return nil, err
+	}
+	return &metadata, nil
+}
+
+// GetAttestedDocument gets the attested data with the nonce, verifies its
+// signature and returns the signed document. It fails if the negotiated API
+// version is older than minAttestedAPIVersion.
+func (c *Client) GetAttestedDocument(nonce string) (*AttestedDocument, error) {
+	apiVersion, err := c.GetAPIVersion()
+	if err != nil {
+		return nil, err
+	}
+	if apiVersion < minAttestedAPIVersion {
+		return nil, fmt.Errorf("attested data requires instance metadata API version %s or newer, got %s", minAttestedAPIVersion, apiVersion)
+	}
+
+	data, err := c.getWithAPIVersion(attestedDocumentPath, map[string]string{"nonce": nonce})
+	if err != nil {
+		return nil, err
+	}
+
+	attested := AttestedData{}
+	if err := json.Unmarshal(data, &attested); err != nil {
+		return nil, err
+	}
+
+	return VerifyAttestedData(&attested, nonce, c.attestation, time.Now())
+}
+
+func (c *Client) getWithAPIVersion(path string, params map[string]string) ([]byte, error) {
+	apiVersion, err := c.GetAPIVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	resp, data, err := c.get(path, apiVersion, params)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failure of getting %s with response %q", path, resp.Status)
+	}
+	return data, nil
+}
+
+func (c *Client) get(path, apiVersion string, params map[string]string) (*http.Response, []byte, error) {
+	req, err := http.NewRequest("GET", c.endpoint+path, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Add("Metadata", "True")
+	req.Header.Add("User-Agent", "golang/kubernetes-cloud-provider")
+
+	q := req.URL.Query()
+	q.Add("format", "json")
+	if apiVersion != "" {
+		q.Add("api-version", apiVersion)
+	}
+	for k, v := range params {
+		q.Add(k, v)
+	}
+	req.URL.RawQuery = q.Encode()
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	return resp, data, nil
+}
@@ -0,0 +1,187 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package imds
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNegotiateAPIVersion(t *testing.T) {
+	testCases := []struct {
+		desc            string
+		serviceVersions []string
+		expected        string
+	}{
+		{
+			desc:            "newest supported version should be used if service supports it",
+			serviceVersions: []string{"2019-03-11", "2019-02-01", "2018-10-01"},
+			expected:        "2019-03-11",
+		},
+		{
+			desc:            "newest supported version not newer than the service should be used",
+			serviceVersions: []string{"2018-02-01", "2018-10-01", "2018-04-02"},
+			expected:        "2018-10-01",
+		},
+		{
+			desc:            "unknown service version between supported versions should be rounded down",
+			serviceVersions: []string{"2018-06-01"},
+			expected:        "2018-04-02",
+		},
+		{
+			desc:            "newest service version should be used if service is older than all supported versions",
+			serviceVersions: []string{"2016-09-01", "2017-03-01"},
+			expected:        "2017-03-01",
+		},
+	}
+
+	for _, test := range testCases {
+		assert.Equal(t, test.expected, negotiateAPIVersion(test.serviceVersions), test.desc)
+	}
+}
+
+func TestGetInstanceMetadata(t *testing.T) {
+	var apiVersions []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "True", r.Header.Get("Metadata"))
+		assert.Equal(t, instancePath, r.URL.Path)
+
+		apiVersion := r.URL.Query().Get("api-version")
+		apiVersions = append(apiVersions, apiVersion)
+		if apiVersion == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error":"Bad request. api-version was not specified in the request","newest-versions":["2018-10-01","2018-04-02","2018-02-01"]}`)
+			return
+		}
+
+		fmt.Fprint(w, `{"compute":{"location":"westus2","name":"vm1","platformFaultDomain":"1","platformUpdateDomain":"2",`+
+			`"publicKeys":[{"keyData":"ssh-rsa AAAA","path":"/home/azureuser/.ssh/authorized_keys"}],`+
+			`"storageProfile":{"osDisk":{"diffDiskSettings":{"option":"Local"},"name":"osdisk"}},`+
+			`"tags":"k1:v1;k2:v2","vmId":"02aab8a4-74ef-476e-8182-f6d2ba4166a6","vmSize":"Standard_DS2_v2","zone":"2"},`+
+			`"network":{"interface":[{"ipv4":{"ipAddress":[{"privateIpAddress":"10.240.0.4","publicIpAddress":""}],"subnet":[{"address":"10.240.0.0","prefix":"16"}]},"macAddress":"000D3A36C0A0"}]}}`)
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, AttestationOptions{})
+	metadata, err := client.GetInstanceMetadata()
+	assert.NoError(t, err)
+	// The API version should be negotiated only once.
+	_, err = client.GetInstanceMetadata()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"", "2018-10-01", "2018-10-01"}, apiVersions)
+
+	compute := metadata.Compute
+	assert.Equal(t, "vm1", compute.Name)
+	assert.Equal(t, "2", compute.Zone)
+	assert.Equal(t, "1", compute.FaultDomain)
+	assert.Equal(t, "02aab8a4-74ef-476e-8182-f6d2ba4166a6", compute.VMID)
+	assert.Equal(t, "Local", compute.StorageProfile.OSDisk.DiffDiskSettings.Option)
+	assert.Equal(t, []PublicKey{{KeyData: "ssh-rsa AAAA", Path: "/home/azureuser/.ssh/authorized_keys"}}, compute.PublicKeys)
+	assert.Equal(t, map[string]string{"k1": "v1", "k2": "v2"}, compute.GetTags())
+	assert.Equal(t, "10.240.0.4", metadata.Network.Interface[0].IPV4.IPAddress[0].PrivateIP)
+}
+
+func TestGetInstanceMetadataError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("api-version") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error":"Bad request"}`)
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, AttestationOptions{})
+	_, err := client.GetInstanceMetadata()
+	assert.Error(t, err)
+	// Fallback version should be used if service doesn't return its versions.
+	apiVersion, err := client.GetAPIVersion()
+	assert.NoError(t, err)
+	assert.Equal(t, fallbackAPIVersion, apiVersion)
+}
+
+func TestGetTags(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		compute  ComputeMetadata
+		expected map[string]string
+	}{
+		{
+			desc:     "tags list should be preferred",
+			compute:  ComputeMetadata{Tags: "a:b", TagsList: []Tag{{Name: "k", Value: "v:1"}}},
+			expected: map[string]string{"k": "v:1"},
+		},
+		{
+			desc:     "tags string should be parsed",
+			compute:  ComputeMetadata{Tags: "k1:v1;k2:;k3"},
+			expected: map[string]string{"k1": "v1", "k2": "", "k3": ""},
+		},
+		{
+			desc:     "empty tags should return empty map",
+			compute:  ComputeMetadata{},
+			expected: map[string]string{},
+		},
+	}
+
+	for _, test := range testCases {
+		assert.Equal(t, test.expected, test.compute.GetTags(), test.desc)
+	}
+}
+
+func TestGetAttestedDocumentAPIVersion(t *testing.T) {
+	testCases := []struct {
+		desc              string
+		newestVersions    string
+		expectedRequested bool
+	}{
+		{
+			desc:           "attested data should not be requested with API versions older than 2018-10-01",
+			newestVersions: `["2018-04-02","2018-02-01"]`,
+		},
+		{
+			desc:              "attested data should be requested with API version 2018-10-01",
+			newestVersions:    `["2018-10-01","2018-04-02"]`,
+			expectedRequested: true,
+		},
+	}
+
+	for _, test := range testCases {
+		requested := false
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			apiVersion := r.URL.Query().Get("api-version")
+			if apiVersion == "" {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprintf(w, `{"error":"Bad request","newest-versions":%s}`, test.newestVersions)
+				return
+			}
+			assert.Equal(t, attestedDocumentPath, r.URL.Path, test.desc)
+			assert.Equal(t, minAttestedAPIVersion, apiVersion, test.desc)
+			requested = true
+			fmt.Fprint(w, `{"encoding":"cms","signature":""}`)
+		}))
+
+		_, err := NewClient(server.URL, AttestationOptions{}).GetAttestedDocument("1234567890")
+		server.Close()
+		assert.Error(t, err, test.desc)
+		assert.Equal(t, test.expectedRequested, requested, test.desc)
+	}
+}
@@ -0,0 +1,202 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package imds
+
+import (
+	"strings"
+)
+
+// InstanceMetadata represents instance information.
+type InstanceMetadata struct {
+	Compute *ComputeMetadata `json:"compute,omitempty"`
+	Network *NetworkMetadata `json:"network,omitempty"`
+}
+
+// ComputeMetadata represents compute information.
+type ComputeMetadata struct {
+	AzEnvironment    string          `json:"azEnvironment,omitempty"`
+	CustomData       string          `json:"customData,omitempty"`
+	Location         string          `json:"location,omitempty"`
+	Name             string          `json:"name,omitempty"`
+	Offer            string          `json:"offer,omitempty"`
+	OSType           string          `json:"osType,omitempty"`
+	PlacementGroupID string          `json:"placementGroupId,omitempty"`
+	Plan             *Plan           `json:"plan,omitempty"`
+	EvictionPolicy   string          `json:"evictionPolicy,omitempty"`
+	FaultDomain      string          `json:"platformFaultDomain,omitempty"`
+	UpdateDomain     string          `json:"platformUpdateDomain,omitempty"`
+	Priority         string          `json:"priority,omitempty"`
+	Provider         string          `json:"provider,omitempty"`
+	PublicKeys       []PublicKey     `json:"publicKeys,omitempty"`
+	Publisher        string          `json:"publisher,omitempty"`
+	ResourceGroup    string          `json:"resourceGroupName,omitempty"`
+	ResourceID       string          `json:"resourceId,omitempty"`
+	SKU              string          `json:"sku,omitempty"`
+	StorageProfile   *StorageProfile `json:"storageProfile,omitempty"`
+	SubscriptionID   string          `json:"subscriptionId,omitempty"`
+	Tags             string          `json:"tags,omitempty"`
+	TagsList         []Tag           `json:"tagsList,omitempty"`
+	Version          string          `json:"version,omitempty"`
+	VMID             string          `json:"vmId,omitempty"`
+	VMScaleSetName   string          `json:"vmScaleSetName,omitempty"`
+	VMSize           string          `json:"vmSize,omitempty"`
+	Zone             string          `json:"zone,omitempty"`
+}
+
+// GetTags returns the tags of the VM. Tags are taken from tagsList if it is
+// returned (newer API versions), otherwise they are parsed from the semicolon
+// separated tags string, e.g. "key1:value1;key2:value2".
+func (c *ComputeMetadata) GetTags() map[string]string {
+	tags := make(map[string]string)
+	if len(c.TagsList) > 0 {
+		for _, tag := range c.TagsList {
+			tags[tag.Name] = tag.Value
+		}
+		return tags
+	}
+
+	for _, pair := range strings.Split(c.Tags, ";") {
+		if pair == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, ":", 2)
+		if len(kv) == 2 {
+			tags[kv[0]] = kv[1]
+		} else {
+			tags[kv[0]] = ""
+		}
+	}
+	return tags
+}
+
+// Tag is a tag of the VM.
+type Tag struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// Plan contains the marketplace plan of the VM image.
+type Plan struct {
+	Name      string `json:"name,omitempty"`
+	Product   string `json:"product,omitempty"`
+	Publisher string `json:"publisher,omitempty"`
+}
+
+// PublicKey is a SSH public key of the VM.
+type PublicKey struct {
+	KeyData string `json:"keyData,omitempty"`
+	Path    string `json:"path,omitempty"`
+}
+
+// StorageProfile contains the image and disks of the VM.
+type StorageProfile struct {
+	ImageReference *ImageReference `json:"imageReference,omitempty"`
+	OSDisk         *Disk           `json:"osDisk,omitempty"`
+	DataDisks      []Disk          `json:"dataDisks,omitempty"`
+}
+
+// ImageReference identifies the image of the VM.
+type ImageReference struct {
+	ID        string `json:"id,omitempty"`
+	Offer     string `json:"offer,omitempty"`
+	Publisher string `json:"publisher,omitempty"`
+	SKU       string `json:"sku,omitempty"`
+	Version   string `json:"version,omitempty"`
+}
+
+// Disk is an OS disk or a data disk of the VM.
+type Disk struct {
+	Caching                 string       `json:"caching,omitempty"`
+	CreateOption            string       `json:"createOption,omitempty"`
+	DiskSizeGB              string       `json:"diskSizeGB,omitempty"`
+	DiffDiskSettings        *DiffDisk    `json:"diffDiskSettings,omitempty"`
+	Image                   *URI         `json:"image,omitempty"`
+	Lun                     string       `json:"lun,omitempty"`
+	ManagedDisk             *ManagedDisk `json:"managedDisk,omitempty"`
+	Name                    string       `json:"name,omitempty"`
+	OSType                  string       `json:"osType,omitempty"`
+	Vhd                     *URI         `json:"vhd,omitempty"`
+	WriteAcceleratorEnabled string       `json:"writeAcceleratorEnabled,omitempty"`
+}
+
+// DiffDisk contains the ephemeral disk settings.
+type DiffDisk struct {
+	Option string `json:"option,omitempty"`
+}
+
+// URI is the URI of a VHD or an image.
+type URI struct {
+	URI string `json:"uri,omitempty"`
+}
+
+// ManagedDisk contains the managed disk parameters.
+type ManagedDisk struct {
+	ID                 string `json:"id,omitempty"`
+	StorageAccountType string `json:"storageAccountType,omitempty"`
+}
+
+// NetworkMetadata contains metadata about an instance's network.
+type NetworkMetadata struct {
+	Interface []NetworkInterface `json:"interface"`
+}
+
+// NetworkInterface represents an instances network interface.
+type NetworkInterface struct {
+	IPV4 NetworkData `json:"ipv4"`
+	IPV6 NetworkData `json:"ipv6"`
+	MAC  string      `json:"macAddress"`
+}
+
+// NetworkData contains IP information for a network.
+type NetworkData struct {
+	IPAddress []IPAddress `json:"ipAddress"`
+	Subnet    []Subnet    `json:"subnet"`
+}
+
+// IPAddress represents IP address information.
+type IPAddress struct {
+	PrivateIP string `json:"privateIpAddress"`
+	PublicIP  string `json:"publicIpAddress"`
+}
+
+// Subnet represents subnet information.
+type Subnet struct {
+	Address string `json:"address"`
+	Prefix  string `json:"prefix"`
+}
+
+// AttestedData is the response of the attested document endpoint.
+type AttestedData struct {
+	Encoding  string `json:"encoding"`
+	Signature string `json:"signature"`
+}
+
+// AttestedDocument is the signed content of the attested data.
+type AttestedDocument struct {
+	Nonce          string             `json:"nonce"`
+	Plan           *Plan              `json:"plan,omitempty"`
+	TimeStamp      *AttestedTimeStamp `json:"timeStamp,omitempty"`
+	VMID           string             `json:"vmId"`
+	SubscriptionID string             `json:"subscriptionId,omitempty"`
+	SKU            string             `json:"sku,omitempty"`
+}
+
+// AttestedTimeStamp is the validity period of the attested document, e.g. "11/28/18 00:16:17 -0000".
+type AttestedTimeStamp struct {
+	CreatedOn string `json:"createdOn"`
+	ExpiresOn string `json:"expiresOn"`
+}
@@ -26,7 +26,9 @@ import (
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
 
+	"k8s.io/cloud-provider-azure/cloud-controller-manager/cloudconfig"
 	"k8s.io/cloud-provider-azure/cloud-controller-manager/version"
+	_ "k8s.io/cloud-provider-azure/cloud-controller-manager/version/prometheus" // for Azure version metric registration
 	cliflag "k8s.io/component-base/cli/flag"
 	"k8s.io/component-base/logs"
 	"k8s.io/kubernetes/cmd/cloud-controller-manager/app"
@@ -55,7 +57,7 @@ func main() {
 	defer logs.FlushLogs()
 
 	// setup for azure
-	var versionFlag *pflag.Value
+	versionFormat := version.FormatNone
 	command.Flags().VisitAll(func(flag *pflag.Flag) {
 		if flag.Name == "cloud-provider" {
 			flag.Value.Set(azureprovider.CloudProviderName)
@@ -69,17 +71,37 @@ func main() {
 		}
 	})
 
+	// The version flag is shared with the upstream command, and is replaced to support more formats.
 	pflag.CommandLine.VisitAll(func(flag *pflag.Flag) {
 		if flag.Name == "version" {
-			versionFlag = &flag.Value
+			flag.Value = &versionFormat
+			flag.Usage = version.FlagUsage
 		}
 	})
 
+	cloudConfigOptions := cloudconfig.NewOptions()
+	cloudConfigOptions.AddFlags(command.Flags())
+	azureOptions := &azureControllerOptions{}
+	azureOptions.AddFlags(command.Flags())
+	files := &cloudConfigFiles{}
+	registerAzureCloud(azureOptions, files)
+	addSubCommands(command, newScheduledEventsCommand(), newValidateConfigCommand(), newDiagnoseCommand())
+
 	command.Use = version.ApplicationName
 	innerRun := command.Run
 	command.Run = func(cmd *cobra.Command, args []string) {
-		if versionFlag != nil && (*versionFlag).String() != "false" {
-			version.PrintAndExit()
+		if versionFormat != version.FormatNone {
+			version.PrintAndExit(versionFormat)
+		}
+		if err := prepareCloudConfig(cloudConfigOptions, cmd.Flags(), files); err != nil {
+			files.Remove()
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		if err := useAzureCloud(cmd.Flags()); err != nil {
+			files.Remove()
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
 		}
 		innerRun(cmd, args)
 	}
@@ -89,3 +111,14 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// addSubCommands adds the Azure specific subcommands. The upstream cloud controller manager
+// prints its own flag sections in help, hence the subcommands are reset to cobra's defaults.
+func addSubCommands(command *cobra.Command, subCommands ...*cobra.Command) {
+	defaultCommand := &cobra.Command{}
+	for _, subCommand := range subCommands {
+		subCommand.SetHelpFunc(defaultCommand.HelpFunc())
+		subCommand.SetUsageFunc(defaultCommand.UsageFunc())
+		command.AddCommand(subCommand)
+	}
+}
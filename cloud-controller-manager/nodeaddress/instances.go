@@ -0,0 +1,532 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package nodeaddress reports the addresses of Azure nodes with options the Azure cloud
+// provider doesn't have: addresses of all network interfaces and IP configurations, IPv6
+// addresses, excluding public IPs, and host names from a VM tag.
+package nodeaddress
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2018-10-01/compute"
+	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2017-09-01/network"
+	"github.com/spf13/pflag"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	cloudprovider "k8s.io/cloud-provider"
+	"k8s.io/cloud-provider-azure/cloud-controller-manager/imds"
+	"k8s.io/cloud-provider-azure/cloud-controller-manager/providerid"
+	"k8s.io/klog"
+	azureprovider "k8s.io/kubernetes/pkg/cloudprovider/providers/azure"
+)
+
+// scaleSetPublicIPExpand expands the public IPs of scale set VMs, which can't be got with
+// the public IP addresses client.
+const scaleSetPublicIPExpand = "ipConfigurations/publicIPAddress"
+
+var (
+	// nicIDRE matches network interface IDs of both standalone VMs and scale set VMs.
+	nicIDRE = regexp.MustCompile(`(?i)/resourceGroups/([^/]+)/providers/Microsoft.(?:Network|Compute)/(?:virtualMachineScaleSets/[^/]+/virtualMachines/[^/]+/)?networkInterfaces/([^/]+)$`)
+	// publicIPIDRE matches IDs of public IPs which are not owned by scale set VMs.
+	publicIPIDRE = regexp.MustCompile(`(?i)/resourceGroups/([^/]+)/providers/Microsoft.Network/publicIPAddresses/([^/]+)$`)
+)
+
+// Options holds the options of the node addresses.
+type Options struct {
+	// IncludeSecondaryIPs reports all IP configurations of all network interfaces, instead of
+	// only the primary IP configuration of the primary network interface.
+	IncludeSecondaryIPs bool
+	// IncludeIPv6 reports the IPv6 addresses.
+	IncludeIPv6 bool
+	// ExcludePublicIPs doesn't report the public IPs as ExternalIP addresses.
+	ExcludePublicIPs bool
+	// HostNameTag is the name of the VM tag whose value is reported as the Hostname and
+	// InternalDNS addresses. The node name is reported as the Hostname address if it is
+	// empty or the VM doesn't have the tag.
+	HostNameTag string
+}
+
+// AddFlags adds flags for the node addresses to the specified FlagSet.
+func (o *Options) AddFlags(fs *pflag.FlagSet) {
+	fs.BoolVar(&o.IncludeSecondaryIPs, "node-addresses-include-secondary-ips", o.IncludeSecondaryIPs,
+		"Report the addresses of all IP configurations of all network interfaces of nodes, instead of only the primary IP configuration of the primary network interface.")
+	fs.BoolVar(&o.IncludeIPv6, "node-addresses-include-ipv6", o.IncludeIPv6,
+		"Report the IPv6 addresses of nodes.")
+	fs.BoolVar(&o.ExcludePublicIPs, "node-addresses-exclude-public-ips", o.ExcludePublicIPs,
+		"Don't report the public IPs of nodes as ExternalIP addresses.")
+	fs.StringVar(&o.HostNameTag, "node-host-name-tag", o.HostNameTag,
+		"Name of the VM tag whose value is reported as the Hostname and InternalDNS addresses of nodes.")
+}
+
+// Enabled returns true if any option is set, otherwise the node addresses are reported by
+// the Azure cloud provider.
+func (o *Options) Enabled() bool {
+	return o.IncludeSecondaryIPs || o.IncludeIPv6 || o.ExcludePublicIPs || o.HostNameTag != ""
+}
+
+// Instances reports the node addresses with the options, and delegates the other methods to
+// the Azure cloud provider. The addresses of the VM the cloud controller manager is running on
+// are got from instance metadata service if it is enabled, and those of other VMs are got from
+// ARM. Both give the same addresses.
+type Instances struct {
+	cloudprovider.Instances
+
+	options Options
+	// metadata is nil if instance metadata service is not used.
+	metadata *imds.Client
+
+	virtualMachinesClient           azureprovider.VirtualMachinesClient
+	virtualMachineScaleSetVMsClient azureprovider.VirtualMachineScaleSetVMsClient
+	interfacesClient                azureprovider.InterfacesClient
+	publicIPAddressesClient         azureprovider.PublicIPAddressesClient
+	isNodeUnmanaged                 func(nodeName string) (bool, error)
+	isNodeUnmanagedByProviderID     func(providerID string) bool
+	getInstanceID                   func(ctx context.Context, name types.NodeName) (string, error)
+}
+
+// NewInstances returns the instances of the Azure cloud provider with the node addresses
+// reported with the options. metadata is used for the local VM if it is not nil.
+func NewInstances(az *azureprovider.Cloud, metadata *imds.Client, options Options) *Instances {
+	return &Instances{
+		Instances: az,
+		options:   options,
+		metadata:  metadata,
+
+		virtualMachinesClient:           az.VirtualMachinesClient,
+		virtualMachineScaleSetVMsClient: az.VirtualMachineScaleSetVMsClient,
+		interfacesClient:                az.InterfacesClient,
+		publicIPAddressesClient:         az.PublicIPAddressesClient,
+		isNodeUnmanaged:                 az.IsNodeUnmanaged,
+		isNodeUnmanagedByProviderID:     az.IsNodeUnmanagedByProviderID,
+		getInstanceID:                   az.InstanceID,
+	}
+}
+
+// instanceInfo is the information of a VM which the node addresses are made of.
+type instanceInfo struct {
+	// hostName is the node name of the VM.
+	hostName string
+	tags     map[string]string
+	// interfaces are the IP addresses of each network interface, the primary network
+	// interface first. The IPv4 addresses of each network interface come first, and the
+	// primary ones come first in both families.
+	interfaces [][]ipAddress
+}
+
+type ipAddress struct {
+	privateIP string
+	publicIP  string
+	ipv6      bool
+}
+
+// NodeAddresses returns the addresses of the node.
+func (i *Instances) NodeAddresses(ctx context.Context, name types.NodeName) ([]v1.NodeAddress, error) {
+	// Returns nil for unmanaged nodes like the Azure cloud provider.
+	unmanaged, err := i.isNodeUnmanaged(string(name))
+	if err != nil {
+		return nil, err
+	}
+	if unmanaged {
+		klog.V(4).Infof("NodeAddresses: omitting unmanaged node %q", name)
+		return nil, nil
+	}
+
+	instanceID, err := i.getInstanceID(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	id, err := providerid.Parse(providerid.Prefix + instanceID)
+	if err != nil {
+		return nil, err
+	}
+	return i.getNodeAddresses(ctx, id, string(name))
+}
+
+// NodeAddressesByProviderID returns the addresses of the node with the provider ID.
+func (i *Instances) NodeAddressesByProviderID(ctx context.Context, providerID string) ([]v1.NodeAddress, error) {
+	if i.isNodeUnmanagedByProviderID(providerID) {
+		klog.V(4).Infof("NodeAddressesByProviderID: omitting unmanaged node %q", providerID)
+		return nil, nil
+	}
+
+	id, err := providerid.Parse(providerID)
+	if err != nil {
+		return nil, err
+	}
+	return i.getNodeAddresses(ctx, id, "")
+}
+
+// getNodeAddresses returns the addresses of the VM. The node name of the VM is looked up
+// if nodeName is empty.
+func (i *Instances) getNodeAddresses(ctx context.Context, id *providerid.ProviderID, nodeName string) ([]v1.NodeAddress, error) {
+	var info *instanceInfo
+	if i.metadata != nil {
+		var err error
+		info, err = i.getLocalInstanceInfo(ctx, id, nodeName)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if info == nil {
+		var err error
+		info, err = i.getInstanceInfo(ctx, id, nodeName)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	addresses := i.options.getNodeAddresses(info)
+	if len(addresses) == 0 || addresses[0].Type != v1.NodeInternalIP {
+		return nil, fmt.Errorf("no IP addresses are found for VM %q", id.ResourceID())
+	}
+	return addresses, nil
+}
+
+// getNodeAddresses returns the addresses of the VM with the options applied: the InternalIP
+// addresses first, then the ExternalIP addresses, and the Hostname and InternalDNS addresses.
+func (o *Options) getNodeAddresses(info *instanceInfo) []v1.NodeAddress {
+	var internal, external []v1.NodeAddress
+	for i, nic := range info.interfaces {
+		if i > 0 && !o.IncludeSecondaryIPs {
+			break
+		}
+
+		hasIPv4, hasIPv6 := false, false
+		for _, ip := range nic {
+			if ip.ipv6 && !o.IncludeIPv6 {
+				continue
+			}
+			if !o.IncludeSecondaryIPs {
+				// Only the primary IP address of each family.
+				if (ip.ipv6 && hasIPv6) || (!ip.ipv6 && hasIPv4) {
+					continue
+				}
+				hasIPv6 = hasIPv6 || ip.ipv6
+				hasIPv4 = hasIPv4 || !ip.ipv6
+			}
+
+			if ip.privateIP != "" {
+				internal = append(internal, v1.NodeAddress{Type: v1.NodeInternalIP, Address: ip.privateIP})
+			}
+			if ip.publicIP != "" && !o.ExcludePublicIPs {
+				external = append(external, v1.NodeAddress{Type: v1.NodeExternalIP, Address: ip.publicIP})
+			}
+		}
+	}
+
+	addresses := append(internal, external...)
+	if hostName := getTag(info.tags, o.HostNameTag); hostName != "" {
+		return append(addresses,
+			v1.NodeAddress{Type: v1.NodeHostName, Address: hostName},
+			v1.NodeAddress{Type: v1.NodeInternalDNS, Address: hostName})
+	}
+	return append(addresses, v1.NodeAddress{Type: v1.NodeHostName, Address: info.hostName})
+}
+
+// getTag returns the value of the tag. Tag names are case-insensitive.
+func getTag(tags map[string]string, name string) string {
+	if name == "" {
+		return ""
+	}
+	for key, value := range tags {
+		if strings.EqualFold(key, name) {
+			return value
+		}
+	}
+	return ""
+}
+
+// getLocalInstanceInfo returns the information of the VM from instance metadata service, or
+// nil if the VM is not the one the cloud controller manager is running on.
+func (i *Instances) getLocalInstanceInfo(ctx context.Context, id *providerid.ProviderID, nodeName string) (*instanceInfo, error) {
+	metadata, err := i.metadata.GetInstanceMetadata()
+	if err != nil {
+		return nil, err
+	}
+	if metadata.Compute == nil || metadata.Network == nil {
+		return nil, fmt.Errorf("failure of getting instance metadata")
+	}
+
+	localID, err := getLocalProviderID(metadata.Compute)
+	if err != nil {
+		return nil, err
+	}
+	if !id.Equal(localID) {
+		return nil, nil
+	}
+
+	if nodeName == "" {
+		nodeName, err = i.getNodeName(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	info := &instanceInfo{
+		hostName: nodeName,
+		tags:     metadata.Compute.GetTags(),
+	}
+	for _, nic := range metadata.Network.Interface {
+		var addresses []ipAddress
+		for _, ip := range nic.IPV4.IPAddress {
+			addresses = append(addresses, ipAddress{privateIP: ip.PrivateIP, publicIP: ip.PublicIP})
+		}
+		for _, ip := range nic.IPV6.IPAddress {
+			addresses = append(addresses, ipAddress{privateIP: ip.PrivateIP, publicIP: ip.PublicIP, ipv6: true})
+		}
+		info.interfaces = append(info.interfaces, addresses)
+	}
+	return info, nil
+}
+
+// getLocalProviderID returns the provider ID of the VM from its compute metadata. Older
+// API versions don't return the resource ID, and the instance ID of a scale set VM is the
+// suffix of its name then, e.g. "ss_0".
+func getLocalProviderID(compute *imds.ComputeMetadata) (*providerid.ProviderID, error) {
+	if compute.ResourceID != "" {
+		return providerid.Parse(providerid.Prefix + compute.ResourceID)
+	}
+	if compute.VMScaleSetName == "" {
+		return providerid.NewVirtualMachine(compute.SubscriptionID, compute.ResourceGroup, compute.Name), nil
+	}
+	instanceID := compute.Name[strings.LastIndex(compute.Name, "_")+1:]
+	return providerid.NewScaleSetVM(compute.SubscriptionID, compute.ResourceGroup, compute.VMScaleSetName, instanceID), nil
+}
+
+// getNodeName returns the node name of the VM like the Azure cloud provider, i.e. the VM name
+// of standalone VMs, and the lower cased computer name of scale set VMs.
+func (i *Instances) getNodeName(ctx context.Context, id *providerid.ProviderID) (string, error) {
+	if !id.IsScaleSetVM() {
+		return id.Name, nil
+	}
+
+	vm, err := i.virtualMachineScaleSetVMsClient.Get(ctx, id.ResourceGroup, id.ScaleSetName, id.Name)
+	if err != nil {
+		return "", err
+	}
+	return getScaleSetVMNodeName(vm)
+}
+
+func getScaleSetVMNodeName(vm compute.VirtualMachineScaleSetVM) (string, error) {
+	if vm.VirtualMachineScaleSetVMProperties == nil || vm.OsProfile == nil || vm.OsProfile.ComputerName == nil {
+		return "", fmt.Errorf("computer name of scale set VM %q is empty", stringValue(vm.ID))
+	}
+	return strings.ToLower(*vm.OsProfile.ComputerName), nil
+}
+
+// getInstanceInfo returns the information of the VM from ARM.
+func (i *Instances) getInstanceInfo(ctx context.Context, id *providerid.ProviderID, nodeName string) (*instanceInfo, error) {
+	if id.IsScaleSetVM() {
+		return i.getScaleSetVMInfo(ctx, id, nodeName)
+	}
+	return i.getStandaloneVMInfo(ctx, id, nodeName)
+}
+
+func (i *Instances) getStandaloneVMInfo(ctx context.Context, id *providerid.ProviderID, nodeName string) (*instanceInfo, error) {
+	vm, err := i.virtualMachinesClient.Get(ctx, id.ResourceGroup, id.Name, "")
+	if err != nil {
+		return nil, err
+	}
+	if vm.VirtualMachineProperties == nil {
+		return nil, fmt.Errorf("properties of VM %q are empty", id.Name)
+	}
+
+	if nodeName == "" {
+		nodeName = id.Name
+	}
+	info := &instanceInfo{
+		hostName: nodeName,
+		tags:     getTags(vm.Tags),
+	}
+
+	nicIDs, err := getInterfaceIDs(vm.NetworkProfile)
+	if err != nil {
+		return nil, err
+	}
+	for _, nicID := range nicIDs {
+		resourceGroup, name, err := parseInterfaceID(nicID)
+		if err != nil {
+			return nil, err
+		}
+		nic, err := i.interfacesClient.Get(ctx, resourceGroup, name, "")
+		if err != nil {
+			return nil, err
+		}
+		addresses, err := i.getInterfaceAddresses(ctx, nic)
+		if err != nil {
+			return nil, err
+		}
+		info.interfaces = append(info.interfaces, addresses)
+	}
+	return info, nil
+}
+
+func (i *Instances) getScaleSetVMInfo(ctx context.Context, id *providerid.ProviderID, nodeName string) (*instanceInfo, error) {
+	vm, err := i.virtualMachineScaleSetVMsClient.Get(ctx, id.ResourceGroup, id.ScaleSetName, id.Name)
+	if err != nil {
+		return nil, err
+	}
+	if vm.VirtualMachineScaleSetVMProperties == nil {
+		return nil, fmt.Errorf("properties of instance %q of scale set %q are empty", id.Name, id.ScaleSetName)
+	}
+
+	if nodeName == "" {
+		nodeName, err = getScaleSetVMNodeName(vm)
+		if err != nil {
+			return nil, err
+		}
+	}
+	info := &instanceInfo{
+		hostName: nodeName,
+		tags:     getTags(vm.Tags),
+	}
+
+	nicIDs, err := getInterfaceIDs(vm.NetworkProfile)
+	if err != nil {
+		return nil, err
+	}
+	for _, nicID := range nicIDs {
+		_, name, err := parseInterfaceID(nicID)
+		if err != nil {
+			return nil, err
+		}
+		nic, err := i.interfacesClient.GetVirtualMachineScaleSetNetworkInterface(ctx, id.ResourceGroup, id.ScaleSetName, id.Name, name, scaleSetPublicIPExpand)
+		if err != nil {
+			return nil, err
+		}
+		addresses, err := i.getInterfaceAddresses(ctx, nic)
+		if err != nil {
+			return nil, err
+		}
+		info.interfaces = append(info.interfaces, addresses)
+	}
+	return info, nil
+}
+
+// getInterfaceIDs returns the IDs of the network interfaces, the primary one first.
+func getInterfaceIDs(profile *compute.NetworkProfile) ([]string, error) {
+	if profile == nil || profile.NetworkInterfaces == nil || len(*profile.NetworkInterfaces) == 0 {
+		return nil, fmt.Errorf("no network interfaces found")
+	}
+
+	var primary, others []string
+	nics := *profile.NetworkInterfaces
+	for _, nic := range nics {
+		if nic.ID == nil {
+			return nil, fmt.Errorf("ID of the network interface is empty")
+		}
+		if len(nics) == 1 || (nic.NetworkInterfaceReferenceProperties != nil && nic.Primary != nil && *nic.Primary) {
+			primary = append(primary, *nic.ID)
+		} else {
+			others = append(others, *nic.ID)
+		}
+	}
+	if len(primary) == 0 {
+		return nil, fmt.Errorf("failed to find a primary network interface")
+	}
+
+	return append(primary, others...), nil
+}
+
+// parseInterfaceID returns the resource group and name of the network interface.
+func parseInterfaceID(nicID string) (string, string, error) {
+	matches := nicIDRE.FindStringSubmatch(nicID)
+	if len(matches) != 3 {
+		return "", "", fmt.Errorf("invalid network interface ID %q", nicID)
+	}
+
+	return matches[1], matches[2], nil
+}
+
+// getInterfaceAddresses returns the IP addresses of the network interface, the IPv4 ones
+// first, and the primary one first in each family.
+func (i *Instances) getInterfaceAddresses(ctx context.Context, nic network.Interface) ([]ipAddress, error) {
+	if nic.InterfacePropertiesFormat == nil || nic.IPConfigurations == nil {
+		return nil, nil
+	}
+
+	var primary, ipv4, ipv6 []ipAddress
+	for _, config := range *nic.IPConfigurations {
+		if config.InterfaceIPConfigurationPropertiesFormat == nil {
+			continue
+		}
+
+		address := ipAddress{
+			privateIP: stringValue(config.PrivateIPAddress),
+			ipv6:      config.PrivateIPAddressVersion == network.IPv6,
+		}
+		if config.PublicIPAddress != nil {
+			publicIP, err := i.getPublicIP(ctx, config.PublicIPAddress)
+			if err != nil {
+				return nil, err
+			}
+			address.publicIP = publicIP
+		}
+
+		switch {
+		case config.Primary != nil && *config.Primary:
+			primary = append(primary, address)
+		case address.ipv6:
+			ipv6 = append(ipv6, address)
+		default:
+			ipv4 = append(ipv4, address)
+		}
+	}
+
+	addresses := append(primary, ipv4...)
+	return append(addresses, ipv6...), nil
+}
+
+// getPublicIP returns the address of the public IP. Public IPs of scale set VMs are expanded
+// in their network interfaces, and the others are got by their IDs.
+func (i *Instances) getPublicIP(ctx context.Context, pip *network.PublicIPAddress) (string, error) {
+	if pip.PublicIPAddressPropertiesFormat != nil && pip.IPAddress != nil {
+		return *pip.IPAddress, nil
+	}
+
+	matches := publicIPIDRE.FindStringSubmatch(stringValue(pip.ID))
+	if len(matches) != 3 {
+		klog.V(4).Infof("Skipping public IP %q which can't be got", stringValue(pip.ID))
+		return "", nil
+	}
+	result, err := i.publicIPAddressesClient.Get(ctx, matches[1], matches[2], "")
+	if err != nil {
+		return "", err
+	}
+	if result.PublicIPAddressPropertiesFormat == nil {
+		return "", nil
+	}
+	return stringValue(result.IPAddress), nil
+}
+
+func getTags(tags map[string]*string) map[string]string {
+	result := make(map[string]string, len(tags))
+	for key, value := range tags {
+		result[key] = stringValue(value)
+	}
+	return result
+}
+
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
@@ -0,0 +1,450 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nodeaddress
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2018-10-01/compute"
+	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2017-09-01/network"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/stretchr/testify/assert"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/cloud-provider-azure/cloud-controller-manager/imds"
+)
+
+const (
+	testVMProviderID       = "azure:///subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm1"
+	testScaleSetProviderID = "azure:///subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachineScaleSets/ss/virtualMachines/0"
+)
+
+type fakeVirtualMachinesClient struct {
+	vms map[string]compute.VirtualMachine
+}
+
+func (f *fakeVirtualMachinesClient) CreateOrUpdate(ctx context.Context, resourceGroupName string, VMName string, parameters compute.VirtualMachine) (*http.Response, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+func (f *fakeVirtualMachinesClient) Get(ctx context.Context, resourceGroupName string, VMName string, expand compute.InstanceViewTypes) (compute.VirtualMachine, error) {
+	vm, ok := f.vms[resourceGroupName+"/"+VMName]
+	if !ok {
+		return compute.VirtualMachine{}, fmt.Errorf("VM %q not found", VMName)
+	}
+	return vm, nil
+}
+
+func (f *fakeVirtualMachinesClient) List(ctx context.Context, resourceGroupName string) ([]compute.VirtualMachine, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+type fakeVirtualMachineScaleSetVMsClient struct {
+	vms map[string]compute.VirtualMachineScaleSetVM
+}
+
+func (f *fakeVirtualMachineScaleSetVMsClient) Get(ctx context.Context, resourceGroupName string, VMScaleSetName string, instanceID string) (compute.VirtualMachineScaleSetVM, error) {
+	vm, ok := f.vms[resourceGroupName+"/"+VMScaleSetName+"/"+instanceID]
+	if !ok {
+		return compute.VirtualMachineScaleSetVM{}, fmt.Errorf("instance %q of scale set %q not found", instanceID, VMScaleSetName)
+	}
+	return vm, nil
+}
+
+func (f *fakeVirtualMachineScaleSetVMsClient) GetInstanceView(ctx context.Context, resourceGroupName string, VMScaleSetName string, instanceID string) (compute.VirtualMachineScaleSetVMInstanceView, error) {
+	return compute.VirtualMachineScaleSetVMInstanceView{}, fmt.Errorf("not implemented")
+}
+
+func (f *fakeVirtualMachineScaleSetVMsClient) List(ctx context.Context, resourceGroupName string, virtualMachineScaleSetName string, filter string, selectParameter string, expand string) ([]compute.VirtualMachineScaleSetVM, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+func (f *fakeVirtualMachineScaleSetVMsClient) Update(ctx context.Context, resourceGroupName string, VMScaleSetName string, instanceID string, parameters compute.VirtualMachineScaleSetVM) (*http.Response, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+type fakeInterfacesClient struct {
+	nics map[string]network.Interface
+}
+
+func (f *fakeInterfacesClient) CreateOrUpdate(ctx context.Context, resourceGroupName string, networkInterfaceName string, parameters network.Interface) (*http.Response, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+func (f *fakeInterfacesClient) Get(ctx context.Context, resourceGroupName string, networkInterfaceName string, expand string) (network.Interface, error) {
+	nic, ok := f.nics[resourceGroupName+"/"+networkInterfaceName]
+	if !ok {
+		return network.Interface{}, fmt.Errorf("network interface %q not found", networkInterfaceName)
+	}
+	return nic, nil
+}
+
+func (f *fakeInterfacesClient) GetVirtualMachineScaleSetNetworkInterface(ctx context.Context, resourceGroupName string, virtualMachineScaleSetName string, virtualmachineIndex string, networkInterfaceName string, expand string) (network.Interface, error) {
+	if expand != scaleSetPublicIPExpand {
+		return network.Interface{}, fmt.Errorf("unexpected expand %q", expand)
+	}
+	nic, ok := f.nics[resourceGroupName+"/"+virtualMachineScaleSetName+"/"+virtualmachineIndex+"/"+networkInterfaceName]
+	if !ok {
+		return network.Interface{}, fmt.Errorf("network interface %q not found", networkInterfaceName)
+	}
+	return nic, nil
+}
+
+type fakePublicIPAddressesClient struct {
+	pips map[string]network.PublicIPAddress
+}
+
+func (f *fakePublicIPAddressesClient) CreateOrUpdate(ctx context.Context, resourceGroupName string, publicIPAddressName string, parameters network.PublicIPAddress) (*http.Response, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+func (f *fakePublicIPAddressesClient) Delete(ctx context.Context, resourceGroupName string, publicIPAddressName string) (*http.Response, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+func (f *fakePublicIPAddressesClient) Get(ctx context.Context, resourceGroupName string, publicIPAddressName string, expand string) (network.PublicIPAddress, error) {
+	pip, ok := f.pips[resourceGroupName+"/"+publicIPAddressName]
+	if !ok {
+		return network.PublicIPAddress{}, fmt.Errorf("public IP %q not found", publicIPAddressName)
+	}
+	return pip, nil
+}
+
+func (f *fakePublicIPAddressesClient) List(ctx context.Context, resourceGroupName string) ([]network.PublicIPAddress, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+func newIPConfig(privateIP string, version network.IPVersion, primary bool, pip *network.PublicIPAddress) network.InterfaceIPConfiguration {
+	return network.InterfaceIPConfiguration{
+		InterfaceIPConfigurationPropertiesFormat: &network.InterfaceIPConfigurationPropertiesFormat{
+			PrivateIPAddress:        to.StringPtr(privateIP),
+			PrivateIPAddressVersion: version,
+			Primary:                 to.BoolPtr(primary),
+			PublicIPAddress:         pip,
+		},
+	}
+}
+
+func newInterface(configs ...network.InterfaceIPConfiguration) network.Interface {
+	return network.Interface{
+		InterfacePropertiesFormat: &network.InterfacePropertiesFormat{IPConfigurations: &configs},
+	}
+}
+
+func newInterfaceReferences(primary string, others ...string) *compute.NetworkProfile {
+	// The primary network interface is listed last, so that it has to be looked up.
+	var refs []compute.NetworkInterfaceReference
+	for _, id := range append(others, primary) {
+		refs = append(refs, compute.NetworkInterfaceReference{
+			ID: to.StringPtr(id),
+			NetworkInterfaceReferenceProperties: &compute.NetworkInterfaceReferenceProperties{
+				Primary: to.BoolPtr(id == primary),
+			},
+		})
+	}
+	return &compute.NetworkProfile{NetworkInterfaces: &refs}
+}
+
+// newTestInstances returns test instances of a standalone VM "vm1" and a scale set VM "ss_0",
+// both with two network interfaces:
+//   - the primary one with a primary IPv4 configuration with a public IP, a secondary IPv4
+//     configuration and an IPv6 configuration
+//   - the secondary one with an IPv4 configuration
+func newTestInstances(options Options, metadata *imds.Client) *Instances {
+	vmssNICPrefix := "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachineScaleSets/ss/virtualMachines/0/networkInterfaces/"
+	return &Instances{
+		options:  options,
+		metadata: metadata,
+		virtualMachinesClient: &fakeVirtualMachinesClient{
+			vms: map[string]compute.VirtualMachine{
+				"rg/vm1": {
+					Tags: map[string]*string{"HostName": to.StringPtr("vm1.contoso.com")},
+					VirtualMachineProperties: &compute.VirtualMachineProperties{
+						NetworkProfile: newInterfaceReferences(
+							"/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Network/networkInterfaces/nic1",
+							"/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Network/networkInterfaces/nic2"),
+					},
+				},
+			},
+		},
+		virtualMachineScaleSetVMsClient: &fakeVirtualMachineScaleSetVMsClient{
+			vms: map[string]compute.VirtualMachineScaleSetVM{
+				"rg/ss/0": {
+					Tags: map[string]*string{"HostName": to.StringPtr("ss-0.contoso.com")},
+					VirtualMachineScaleSetVMProperties: &compute.VirtualMachineScaleSetVMProperties{
+						OsProfile:      &compute.OSProfile{ComputerName: to.StringPtr("SS000000")},
+						NetworkProfile: newInterfaceReferences(vmssNICPrefix+"ss-nic1", vmssNICPrefix+"ss-nic2"),
+					},
+				},
+			},
+		},
+		interfacesClient: &fakeInterfacesClient{
+			nics: map[string]network.Interface{
+				"rg/nic1": newInterface(
+					newIPConfig("10.0.0.5", network.IPv4, false, nil),
+					newIPConfig("fd00::4", network.IPv6, false, nil),
+					newIPConfig("10.0.0.4", network.IPv4, true, &network.PublicIPAddress{
+						ID: to.StringPtr("/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Network/publicIPAddresses/pip1"),
+					})),
+				"rg/nic2": newInterface(newIPConfig("10.1.0.4", network.IPv4, true, nil)),
+				"rg/ss/0/ss-nic1": newInterface(
+					newIPConfig("10.0.1.5", network.IPv4, false, nil),
+					newIPConfig("fd00::1:4", network.IPv6, false, nil),
+					newIPConfig("10.0.1.4", network.IPv4, true, &network.PublicIPAddress{
+						ID:                              to.StringPtr(vmssNICPrefix + "ss-nic1/ipConfigurations/ipconfig1/publicIPAddresses/pip"),
+						PublicIPAddressPropertiesFormat: &network.PublicIPAddressPropertiesFormat{IPAddress: to.StringPtr("20.0.1.1")},
+					})),
+				"rg/ss/0/ss-nic2": newInterface(newIPConfig("10.1.1.4", network.IPv4, true, nil)),
+			},
+		},
+		publicIPAddressesClient: &fakePublicIPAddressesClient{
+			pips: map[string]network.PublicIPAddress{
+				"rg/pip1": {PublicIPAddressPropertiesFormat: &network.PublicIPAddressPropertiesFormat{IPAddress: to.StringPtr("20.0.0.1")}},
+			},
+		},
+		isNodeUnmanaged:             func(nodeName string) (bool, error) { return false, nil },
+		isNodeUnmanagedByProviderID: func(providerID string) bool { return !strings.HasPrefix(providerID, "azure://") },
+		getInstanceID: func(ctx context.Context, name types.NodeName) (string, error) {
+			if name == "ss000000" {
+				return strings.TrimPrefix(testScaleSetProviderID, "azure://"), nil
+			}
+			return strings.TrimPrefix(testVMProviderID, "azure://"), nil
+		},
+	}
+}
+
+// newFakeMetadataServer serves the instance metadata.
+func newFakeMetadataServer(metadata string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("api-version") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error": "Bad request", "newest-versions": ["2019-03-11"]}`)
+			return
+		}
+		fmt.Fprint(w, metadata)
+	}))
+}
+
+// testVMMetadata and testScaleSetVMMetadata are the instance metadata of the VMs of newTestInstances.
+const (
+	testVMMetadata = `{
+		"compute": {"name": "vm1", "resourceGroupName": "rg", "subscriptionId": "sub", "tags": "HostName:vm1.contoso.com"},
+		"network": {"interface": [
+			{"ipv4": {"ipAddress": [{"privateIpAddress": "10.0.0.4", "publicIpAddress": "20.0.0.1"}, {"privateIpAddress": "10.0.0.5"}]},
+			 "ipv6": {"ipAddress": [{"privateIpAddress": "fd00::4"}]}},
+			{"ipv4": {"ipAddress": [{"privateIpAddress": "10.1.0.4"}]}, "ipv6": {"ipAddress": []}}]}}`
+	testScaleSetVMMetadata = `{
+		"compute": {"name": "ss_0", "vmScaleSetName": "ss", "resourceGroupName": "rg", "subscriptionId": "sub", "tagsList": [{"name": "HostName", "value": "ss-0.contoso.com"}]},
+		"network": {"interface": [
+			{"ipv4": {"ipAddress": [{"privateIpAddress": "10.0.1.4", "publicIpAddress": "20.0.1.1"}, {"privateIpAddress": "10.0.1.5"}]},
+			 "ipv6": {"ipAddress": [{"privateIpAddress": "fd00::1:4"}]}},
+			{"ipv4": {"ipAddress": [{"privateIpAddress": "10.1.1.4"}]}, "ipv6": {"ipAddress": []}}]}}`
+)
+
+func TestNodeAddressesFromARMAndMetadata(t *testing.T) {
+	testCases := []struct {
+		desc       string
+		providerID string
+		nodeName   string
+		metadata   string
+		options    Options
+		expected   []v1.NodeAddress
+	}{
+		{
+			desc:       "primary addresses of the primary network interface should be returned by default",
+			providerID: testVMProviderID,
+			nodeName:   "vm1",
+			metadata:   testVMMetadata,
+			expected: []v1.NodeAddress{
+				{Type: v1.NodeInternalIP, Address: "10.0.0.4"},
+				{Type: v1.NodeExternalIP, Address: "20.0.0.1"},
+				{Type: v1.NodeHostName, Address: "vm1"},
+			},
+		},
+		{
+			desc:       "addresses of all IP configurations of all network interfaces should be returned",
+			providerID: testVMProviderID,
+			nodeName:   "vm1",
+			metadata:   testVMMetadata,
+			options:    Options{IncludeSecondaryIPs: true},
+			expected: []v1.NodeAddress{
+				{Type: v1.NodeInternalIP, Address: "10.0.0.4"},
+				{Type: v1.NodeInternalIP, Address: "10.0.0.5"},
+				{Type: v1.NodeInternalIP, Address: "10.1.0.4"},
+				{Type: v1.NodeExternalIP, Address: "20.0.0.1"},
+				{Type: v1.NodeHostName, Address: "vm1"},
+			},
+		},
+		{
+			desc:       "primary IPv6 address should be returned",
+			providerID: testVMProviderID,
+			nodeName:   "vm1",
+			metadata:   testVMMetadata,
+			options:    Options{IncludeIPv6: true},
+			expected: []v1.NodeAddress{
+				{Type: v1.NodeInternalIP, Address: "10.0.0.4"},
+				{Type: v1.NodeInternalIP, Address: "fd00::4"},
+				{Type: v1.NodeExternalIP, Address: "20.0.0.1"},
+				{Type: v1.NodeHostName, Address: "vm1"},
+			},
+		},
+		{
+			desc:       "all addresses except public IPs should be returned",
+			providerID: testVMProviderID,
+			nodeName:   "vm1",
+			metadata:   testVMMetadata,
+			options:    Options{IncludeSecondaryIPs: true, IncludeIPv6: true, ExcludePublicIPs: true},
+			expected: []v1.NodeAddress{
+				{Type: v1.NodeInternalIP, Address: "10.0.0.4"},
+				{Type: v1.NodeInternalIP, Address: "10.0.0.5"},
+				{Type: v1.NodeInternalIP, Address: "fd00::4"},
+				{Type: v1.NodeInternalIP, Address: "10.1.0.4"},
+				{Type: v1.NodeHostName, Address: "vm1"},
+			},
+		},
+		{
+			desc:       "host name should be taken from the tag",
+			providerID: testVMProviderID,
+			nodeName:   "vm1",
+			metadata:   testVMMetadata,
+			options:    Options{HostNameTag: "hostname"},
+			expected: []v1.NodeAddress{
+				{Type: v1.NodeInternalIP, Address: "10.0.0.4"},
+				{Type: v1.NodeExternalIP, Address: "20.0.0.1"},
+				{Type: v1.NodeHostName, Address: "vm1.contoso.com"},
+				{Type: v1.NodeInternalDNS, Address: "vm1.contoso.com"},
+			},
+		},
+		{
+			desc:       "node name should be the host name if the VM doesn't have the tag",
+			providerID: testVMProviderID,
+			nodeName:   "vm1",
+			metadata:   testVMMetadata,
+			options:    Options{HostNameTag: "fqdn"},
+			expected: []v1.NodeAddress{
+				{Type: v1.NodeInternalIP, Address: "10.0.0.4"},
+				{Type: v1.NodeExternalIP, Address: "20.0.0.1"},
+				{Type: v1.NodeHostName, Address: "vm1"},
+			},
+		},
+		{
+			desc:       "addresses of scale set VM should be returned",
+			providerID: testScaleSetProviderID,
+			nodeName:   "ss000000",
+			metadata:   testScaleSetVMMetadata,
+			options:    Options{IncludeSecondaryIPs: true, IncludeIPv6: true},
+			expected: []v1.NodeAddress{
+				{Type: v1.NodeInternalIP, Address: "10.0.1.4"},
+				{Type: v1.NodeInternalIP, Address: "10.0.1.5"},
+				{Type: v1.NodeInternalIP, Address: "fd00::1:4"},
+				{Type: v1.NodeInternalIP, Address: "10.1.1.4"},
+				{Type: v1.NodeExternalIP, Address: "20.0.1.1"},
+				{Type: v1.NodeHostName, Address: "ss000000"},
+			},
+		},
+		{
+			desc:       "host name of scale set VM should be taken from the tag",
+			providerID: testScaleSetProviderID,
+			nodeName:   "ss000000",
+			metadata:   testScaleSetVMMetadata,
+			options:    Options{HostNameTag: "HostName", ExcludePublicIPs: true},
+			expected: []v1.NodeAddress{
+				{Type: v1.NodeInternalIP, Address: "10.0.1.4"},
+				{Type: v1.NodeHostName, Address: "ss-0.contoso.com"},
+				{Type: v1.NodeInternalDNS, Address: "ss-0.contoso.com"},
+			},
+		},
+	}
+
+	for _, test := range testCases {
+		server := newFakeMetadataServer(test.metadata)
+		metadata := imds.NewClient(server.URL, imds.AttestationOptions{})
+
+		for _, path := range []struct {
+			name      string
+			instances *Instances
+		}{
+			{name: "ARM", instances: newTestInstances(test.options, nil)},
+			{name: "instance metadata", instances: newTestInstances(test.options, metadata)},
+		} {
+			// ARM is not used for the addresses when instance metadata service is used.
+			if path.instances.metadata != nil {
+				path.instances.interfacesClient = &fakeInterfacesClient{}
+			}
+
+			addresses, err := path.instances.NodeAddressesByProviderID(context.Background(), test.providerID)
+			assert.NoError(t, err, "%s: %s", path.name, test.desc)
+			assert.Equal(t, test.expected, addresses, "%s: %s", path.name, test.desc)
+
+			addresses, err = path.instances.NodeAddresses(context.Background(), types.NodeName(test.nodeName))
+			assert.NoError(t, err, "%s: %s", path.name, test.desc)
+			assert.Equal(t, test.expected, addresses, "%s: %s", path.name, test.desc)
+		}
+
+		server.Close()
+	}
+}
+
+func TestNodeAddressesOfOtherVMFromARM(t *testing.T) {
+	// The cloud controller manager is running on another VM.
+	server := newFakeMetadataServer(strings.Replace(testVMMetadata, `"vm1"`, `"vm2"`, 1))
+	defer server.Close()
+	instances := newTestInstances(Options{IncludeSecondaryIPs: true}, imds.NewClient(server.URL, imds.AttestationOptions{}))
+
+	addresses, err := instances.NodeAddressesByProviderID(context.Background(), testVMProviderID)
+	assert.NoError(t, err)
+	assert.Equal(t, []v1.NodeAddress{
+		{Type: v1.NodeInternalIP, Address: "10.0.0.4"},
+		{Type: v1.NodeInternalIP, Address: "10.0.0.5"},
+		{Type: v1.NodeInternalIP, Address: "10.1.0.4"},
+		{Type: v1.NodeExternalIP, Address: "20.0.0.1"},
+		{Type: v1.NodeHostName, Address: "vm1"},
+	}, addresses)
+}
+
+func TestNodeAddressesOfUnmanagedNode(t *testing.T) {
+	instances := newTestInstances(Options{IncludeSecondaryIPs: true}, nil)
+	addresses, err := instances.NodeAddressesByProviderID(context.Background(), "aws:///us-east-1a/i-0123456789")
+	assert.NoError(t, err)
+	assert.Nil(t, addresses)
+
+	instances.isNodeUnmanaged = func(nodeName string) (bool, error) { return true, nil }
+	addresses, err = instances.NodeAddresses(context.Background(), "on-prem-node")
+	assert.NoError(t, err)
+	assert.Nil(t, addresses)
+}
+
+func TestNodeAddressesWithoutIPAddresses(t *testing.T) {
+	instances := newTestInstances(Options{IncludeSecondaryIPs: true}, nil)
+	instances.interfacesClient.(*fakeInterfacesClient).nics["rg/nic1"] = newInterface()
+	instances.interfacesClient.(*fakeInterfacesClient).nics["rg/nic2"] = newInterface()
+
+	_, err := instances.NodeAddressesByProviderID(context.Background(), testVMProviderID)
+	assert.Error(t, err)
+}
+
+func TestOptionsEnabled(t *testing.T) {
+	assert.False(t, (&Options{}).Enabled())
+	assert.True(t, (&Options{ExcludePublicIPs: true}).Enabled())
+	assert.True(t, (&Options{HostNameTag: "fqdn"}).Enabled())
+}
@@ -0,0 +1,511 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nodelabel
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2018-10-01/compute"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	coreinformers "k8s.io/client-go/informers/core/v1"
+	clientset "k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/cloud-provider-azure/cloud-controller-manager/providerid"
+	"k8s.io/klog"
+	azureprovider "k8s.io/kubernetes/pkg/cloudprovider/providers/azure"
+)
+
+const (
+	// maxRetries is the number of times a node will be retried before it is dropped out of the queue.
+	maxRetries = 5
+
+	// managedByAzureLabel is set to "false" for nodes which are not managed by Azure cloud provider (e.g. on-prem nodes).
+	managedByAzureLabel = "kubernetes.azure.com/managed"
+)
+
+var (
+	// nicIDRE matches network interface IDs of both standalone VMs and scale set VMs.
+	nicIDRE = regexp.MustCompile(`(?i)/resourceGroups/([^/]+)/providers/Microsoft.(?:Network|Compute)/(?:virtualMachineScaleSets/[^/]+/virtualMachines/[^/]+/)?networkInterfaces/([^/]+)$`)
+)
+
+// Controller adds Azure specific labels (fault domain, update domain, scale set and
+// VM SKU capabilities) to nodes.
+type Controller struct {
+	kubeClient clientset.Interface
+	nodeLister corelisters.NodeLister
+	nodeSynced cache.InformerSynced
+	queue      workqueue.RateLimitingInterface
+
+	location                        string
+	virtualMachinesClient           azureprovider.VirtualMachinesClient
+	virtualMachineScaleSetVMsClient azureprovider.VirtualMachineScaleSetVMsClient
+	interfacesClient                azureprovider.InterfacesClient
+	virtualMachineSizesClient       azureprovider.VirtualMachineSizesClient
+	isNodeUnmanaged                 func(providerID string) bool
+
+	// resyncPeriod is the interval at which all nodes are relabeled.
+	resyncPeriod time.Duration
+
+	// labeledNodes maps names of labeled nodes to their provider IDs. failedNodes maps names of
+	// nodes which are dropped out of the queue after maxRetries to their provider IDs, and they
+	// are only retried by the periodical relabeling or when their provider IDs change.
+	// skippedNodes maps names of unmanaged nodes and nodes without provider IDs to getSkipKey
+	// of the nodes, and they are only synced again when it changes.
+	nodesLock    sync.Mutex
+	labeledNodes map[string]string
+	failedNodes  map[string]string
+	skippedNodes map[string]string
+
+	// vmSizes caches VM sizes of each location, keyed by lower cased VM size name.
+	vmSizesLock sync.Mutex
+	vmSizes     map[string]map[string]compute.VirtualMachineSize
+}
+
+// NewController returns a new node label controller.
+func NewController(
+	kubeClient clientset.Interface,
+	nodeInformer coreinformers.NodeInformer,
+	az *azureprovider.Cloud,
+	resyncPeriod time.Duration) *Controller {
+	c := &Controller{
+		kubeClient: kubeClient,
+		nodeLister: nodeInformer.Lister(),
+		nodeSynced: nodeInformer.Informer().HasSynced,
+		queue:      workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "azure-node-label"),
+
+		location:                        az.Location,
+		virtualMachinesClient:           az.VirtualMachinesClient,
+		virtualMachineScaleSetVMsClient: az.VirtualMachineScaleSetVMsClient,
+		interfacesClient:                az.InterfacesClient,
+		virtualMachineSizesClient:       az.VirtualMachineSizesClient,
+		isNodeUnmanaged:                 az.IsNodeUnmanagedByProviderID,
+
+		resyncPeriod: resyncPeriod,
+		labeledNodes: make(map[string]string),
+		failedNodes:  make(map[string]string),
+		skippedNodes: make(map[string]string),
+		vmSizes:      make(map[string]map[string]compute.VirtualMachineSize),
+	}
+
+	nodeInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    c.enqueueNode,
+		UpdateFunc: c.updateNode,
+		DeleteFunc: func(obj interface{}) {
+			key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+			if err != nil {
+				utilruntime.HandleError(err)
+				return
+			}
+			c.nodesLock.Lock()
+			delete(c.labeledNodes, key)
+			delete(c.failedNodes, key)
+			delete(c.skippedNodes, key)
+			c.nodesLock.Unlock()
+		},
+	})
+
+	return c
+}
+
+// Run starts the workers and blocks until stopCh is closed.
+func (c *Controller) Run(workers int, stopCh <-chan struct{}) {
+	defer utilruntime.HandleCrash()
+	defer c.queue.ShutDown()
+
+	klog.Infof("Starting Azure node label controller")
+	defer klog.Infof("Shutting down Azure node label controller")
+
+	if !cache.WaitForCacheSync(stopCh, c.nodeSynced) {
+		utilruntime.HandleError(fmt.Errorf("timed out waiting for caches to sync"))
+		return
+	}
+
+	for i := 0; i < workers; i++ {
+		go wait.Until(c.worker, time.Second, stopCh)
+	}
+
+	// VM sizes are refreshed together with the periodical relabeling.
+	go wait.Until(c.resync, c.resyncPeriod, stopCh)
+
+	<-stopCh
+}
+
+// updateNode enqueues the updated node if it needs to be synced.
+func (c *Controller) updateNode(old, cur interface{}) {
+	if c.needsSync(cur.(*v1.Node)) {
+		c.enqueueNode(cur)
+	}
+}
+
+// needsSync returns whether the updated node should be enqueued. Node status is updated
+// frequently, so nodes which are labeled, waiting for a retry, or dropped out of the queue
+// are only enqueued again if their provider IDs have changed, and skipped nodes only if
+// their provider IDs or managed labels have changed.
+func (c *Controller) needsSync(node *v1.Node) bool {
+	c.nodesLock.Lock()
+	defer c.nodesLock.Unlock()
+
+	if key, ok := c.skippedNodes[node.Name]; ok {
+		return key != getSkipKey(node)
+	}
+	if providerID, ok := c.labeledNodes[node.Name]; ok {
+		return providerID != node.Spec.ProviderID
+	}
+	if providerID, ok := c.failedNodes[node.Name]; ok {
+		return providerID != node.Spec.ProviderID
+	}
+	// Enqueuing the node would bypass the rate limited retry.
+	return c.queue.NumRequeues(node.Name) == 0
+}
+
+func (c *Controller) enqueueNode(obj interface{}) {
+	key, err := cache.MetaNamespaceKeyFunc(obj)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	c.queue.Add(key)
+}
+
+// resync enqueues all nodes, so that labels changed by e.g. VM redeployment or resizing are updated.
+func (c *Controller) resync() {
+	c.vmSizesLock.Lock()
+	c.vmSizes = make(map[string]map[string]compute.VirtualMachineSize)
+	c.vmSizesLock.Unlock()
+
+	nodes, err := c.nodeLister.List(labels.Everything())
+	if err != nil {
+		utilruntime.HandleError(fmt.Errorf("failed to list nodes: %v", err))
+		return
+	}
+	for _, node := range nodes {
+		c.enqueueNode(node)
+	}
+}
+
+func (c *Controller) worker() {
+	for c.processNextWorkItem() {
+	}
+}
+
+func (c *Controller) processNextWorkItem() bool {
+	key, quit := c.queue.Get()
+	if quit {
+		return false
+	}
+	defer c.queue.Done(key)
+
+	err := c.syncNode(key.(string))
+	if err == nil {
+		c.queue.Forget(key)
+		return true
+	}
+
+	if c.queue.NumRequeues(key) < maxRetries {
+		klog.V(2).Infof("Error labeling node %q, retrying: %v", key, err)
+		c.queue.AddRateLimited(key)
+		return true
+	}
+
+	utilruntime.HandleError(fmt.Errorf("dropping node %q out of the queue until next relabeling: %v", key, err))
+	c.queue.Forget(key)
+	if node, err := c.nodeLister.Get(key.(string)); err == nil {
+		c.nodesLock.Lock()
+		c.failedNodes[node.Name] = node.Spec.ProviderID
+		c.nodesLock.Unlock()
+	}
+	return true
+}
+
+func (c *Controller) syncNode(key string) error {
+	node, err := c.nodeLister.Get(key)
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// Provider ID is set by the cloud node controller, the node would be enqueued again after that.
+	providerID := node.Spec.ProviderID
+	if providerID == "" {
+		klog.V(4).Infof("Skipping node %q because its provider ID is not set yet", node.Name)
+		c.skipNode(node)
+		return nil
+	}
+	if node.Labels[managedByAzureLabel] == "false" || c.isNodeUnmanaged(providerID) {
+		klog.V(4).Infof("Skipping unmanaged node %q", node.Name)
+		c.skipNode(node)
+		return nil
+	}
+
+	info, err := c.getInstanceInfo(providerID)
+	if err != nil {
+		return err
+	}
+
+	size, err := c.getVMSize(info.vmSize)
+	if err != nil {
+		return err
+	}
+
+	if err := c.patchNodeLabels(node, getNodeLabels(info, size)); err != nil {
+		return err
+	}
+
+	c.nodesLock.Lock()
+	c.labeledNodes[node.Name] = providerID
+	delete(c.failedNodes, node.Name)
+	delete(c.skippedNodes, node.Name)
+	c.nodesLock.Unlock()
+	return nil
+}
+
+// skipNode records that node is skipped until its provider ID or managed label changes.
+func (c *Controller) skipNode(node *v1.Node) {
+	c.nodesLock.Lock()
+	defer c.nodesLock.Unlock()
+	c.skippedNodes[node.Name] = getSkipKey(node)
+	delete(c.labeledNodes, node.Name)
+	delete(c.failedNodes, node.Name)
+}
+
+// getSkipKey returns the properties which decide whether node is skipped.
+func getSkipKey(node *v1.Node) string {
+	managed, ok := node.Labels[managedByAzureLabel]
+	return fmt.Sprintf("%s/%t/%s", node.Spec.ProviderID, ok, managed)
+}
+
+// patchNodeLabels patches node with the labels which are different from current ones.
+func (c *Controller) patchNodeLabels(node *v1.Node, desired map[string]string) error {
+	changed := make(map[string]interface{})
+	for _, key := range managedLabels {
+		value, ok := desired[key]
+		current, exists := node.Labels[key]
+		switch {
+		case ok && (!exists || current != value):
+			changed[key] = value
+		case !ok && exists:
+			// Removes the stale label.
+			changed[key] = nil
+		}
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"labels": changed,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	klog.V(2).Infof("Patching labels %v to node %q", changed, node.Name)
+	_, err = c.kubeClient.CoreV1().Nodes().Patch(node.Name, types.StrategicMergePatchType, patch)
+	return err
+}
+
+// getInstanceInfo gets the labeled properties of the VM identified by providerID.
+func (c *Controller) getInstanceInfo(providerID string) (*instanceInfo, error) {
+	id, err := providerid.Parse(providerID)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if id.IsScaleSetVM() {
+		return c.getScaleSetVMInfo(ctx, id.ResourceGroup, id.ScaleSetName, id.Name)
+	}
+	return c.getStandaloneVMInfo(ctx, id.ResourceGroup, id.Name)
+}
+
+func (c *Controller) getStandaloneVMInfo(ctx context.Context, resourceGroup, name string) (*instanceInfo, error) {
+	vm, err := c.virtualMachinesClient.Get(ctx, resourceGroup, name, compute.InstanceView)
+	if err != nil {
+		return nil, err
+	}
+	if vm.VirtualMachineProperties == nil {
+		return nil, fmt.Errorf("properties of VM %q are empty", name)
+	}
+
+	info := &instanceInfo{
+		ephemeralOSDisk: isEphemeralOSDisk(vm.StorageProfile),
+	}
+	if vm.InstanceView != nil {
+		info.faultDomain = vm.InstanceView.PlatformFaultDomain
+		info.updateDomain = vm.InstanceView.PlatformUpdateDomain
+	}
+	if vm.HardwareProfile != nil {
+		info.vmSize = string(vm.HardwareProfile.VMSize)
+	}
+
+	nicID, err := getPrimaryInterfaceID(vm.NetworkProfile)
+	if err != nil {
+		return nil, err
+	}
+	nicResourceGroup, nicName, err := parseInterfaceID(nicID)
+	if err != nil {
+		return nil, err
+	}
+	nic, err := c.interfacesClient.Get(ctx, nicResourceGroup, nicName, "")
+	if err != nil {
+		return nil, err
+	}
+	if nic.InterfacePropertiesFormat != nil {
+		info.acceleratedNetworking = nic.EnableAcceleratedNetworking
+	}
+
+	return info, nil
+}
+
+func (c *Controller) getScaleSetVMInfo(ctx context.Context, resourceGroup, scaleSetName, instanceID string) (*instanceInfo, error) {
+	vm, err := c.virtualMachineScaleSetVMsClient.Get(ctx, resourceGroup, scaleSetName, instanceID)
+	if err != nil {
+		return nil, err
+	}
+	if vm.VirtualMachineScaleSetVMProperties == nil {
+		return nil, fmt.Errorf("properties of instance %q of scale set %q are empty", instanceID, scaleSetName)
+	}
+
+	info := &instanceInfo{
+		scaleSetName:    scaleSetName,
+		ephemeralOSDisk: isEphemeralOSDisk(vm.StorageProfile),
+	}
+	if vm.Sku != nil && vm.Sku.Name != nil {
+		info.vmSize = *vm.Sku.Name
+	} else if vm.HardwareProfile != nil {
+		info.vmSize = string(vm.HardwareProfile.VMSize)
+	}
+
+	instanceView, err := c.virtualMachineScaleSetVMsClient.GetInstanceView(ctx, resourceGroup, scaleSetName, instanceID)
+	if err != nil {
+		return nil, err
+	}
+	info.faultDomain = instanceView.PlatformFaultDomain
+	info.updateDomain = instanceView.PlatformUpdateDomain
+
+	nicID, err := getPrimaryInterfaceID(vm.NetworkProfile)
+	if err != nil {
+		return nil, err
+	}
+	_, nicName, err := parseInterfaceID(nicID)
+	if err != nil {
+		return nil, err
+	}
+	nic, err := c.interfacesClient.GetVirtualMachineScaleSetNetworkInterface(ctx, resourceGroup, scaleSetName, instanceID, nicName, "")
+	if err != nil {
+		return nil, err
+	}
+	if nic.InterfacePropertiesFormat != nil {
+		info.acceleratedNetworking = nic.EnableAcceleratedNetworking
+	}
+
+	return info, nil
+}
+
+// getVMSize gets the capabilities of VM size from cache. It returns nil if the size is not found.
+func (c *Controller) getVMSize(vmSize string) (*compute.VirtualMachineSize, error) {
+	if vmSize == "" {
+		return nil, nil
+	}
+
+	c.vmSizesLock.Lock()
+	sizes, ok := c.vmSizes[c.location]
+	c.vmSizesLock.Unlock()
+
+	// The sizes are listed without holding the lock, so that workers aren't blocked by a
+	// slow request. Concurrent misses may list the sizes more than once.
+	if !ok {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		result, err := c.virtualMachineSizesClient.List(ctx, c.location)
+		if err != nil {
+			return nil, err
+		}
+
+		sizes = make(map[string]compute.VirtualMachineSize)
+		if result.Value != nil {
+			for _, size := range *result.Value {
+				if size.Name != nil {
+					sizes[strings.ToLower(*size.Name)] = size
+				}
+			}
+		}
+		c.vmSizesLock.Lock()
+		c.vmSizes[c.location] = sizes
+		c.vmSizesLock.Unlock()
+	}
+
+	size, ok := sizes[strings.ToLower(vmSize)]
+	if !ok {
+		klog.V(2).Infof("VM size %q is not found in location %q", vmSize, c.location)
+		return nil, nil
+	}
+	return &size, nil
+}
+
+// getPrimaryInterfaceID gets the ID of the primary network interface from the VM's network profile.
+func getPrimaryInterfaceID(profile *compute.NetworkProfile) (string, error) {
+	if profile == nil || profile.NetworkInterfaces == nil || len(*profile.NetworkInterfaces) == 0 {
+		return "", fmt.Errorf("no network interfaces found")
+	}
+
+	nics := *profile.NetworkInterfaces
+	if len(nics) == 1 {
+		if nics[0].ID == nil {
+			return "", fmt.Errorf("ID of the network interface is empty")
+		}
+		return *nics[0].ID, nil
+	}
+
+	for _, nic := range nics {
+		if nic.ID != nil && nic.NetworkInterfaceReferenceProperties != nil &&
+			nic.Primary != nil && *nic.Primary {
+			return *nic.ID, nil
+		}
+	}
+
+	return "", fmt.Errorf("failed to find a primary network interface")
+}
+
+// parseInterfaceID returns the resource group and name of the network interface.
+func parseInterfaceID(nicID string) (string, string, error) {
+	matches := nicIDRE.FindStringSubmatch(nicID)
+	if len(matches) != 3 {
+		return "", "", fmt.Errorf("invalid network interface ID %q", nicID)
+	}
+
+	return matches[1], matches[2], nil
+}
@@ -0,0 +1,523 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nodelabel
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2018-10-01/compute"
+	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2017-09-01/network"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/stretchr/testify/assert"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	core "k8s.io/client-go/testing"
+	"k8s.io/client-go/util/workqueue"
+)
+
+const (
+	testVMProviderID      = "azure:///subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm1"
+	testMissingProviderID = "azure:///subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm2"
+)
+
+type fakeVirtualMachinesClient struct {
+	vms map[string]compute.VirtualMachine
+}
+
+func (f *fakeVirtualMachinesClient) CreateOrUpdate(ctx context.Context, resourceGroupName string, VMName string, parameters compute.VirtualMachine) (*http.Response, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+func (f *fakeVirtualMachinesClient) Get(ctx context.Context, resourceGroupName string, VMName string, expand compute.InstanceViewTypes) (compute.VirtualMachine, error) {
+	if vm, ok := f.vms[resourceGroupName+"/"+VMName]; ok {
+		return vm, nil
+	}
+	return compute.VirtualMachine{}, fmt.Errorf("VM %s/%s not found", resourceGroupName, VMName)
+}
+
+func (f *fakeVirtualMachinesClient) List(ctx context.Context, resourceGroupName string) ([]compute.VirtualMachine, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+type fakeVirtualMachineScaleSetVMsClient struct {
+	vms           map[string]compute.VirtualMachineScaleSetVM
+	instanceViews map[string]compute.VirtualMachineScaleSetVMInstanceView
+}
+
+func (f *fakeVirtualMachineScaleSetVMsClient) Get(ctx context.Context, resourceGroupName string, VMScaleSetName string, instanceID string) (compute.VirtualMachineScaleSetVM, error) {
+	if vm, ok := f.vms[resourceGroupName+"/"+VMScaleSetName+"/"+instanceID]; ok {
+		return vm, nil
+	}
+	return compute.VirtualMachineScaleSetVM{}, fmt.Errorf("instance %s not found", instanceID)
+}
+
+func (f *fakeVirtualMachineScaleSetVMsClient) GetInstanceView(ctx context.Context, resourceGroupName string, VMScaleSetName string, instanceID string) (compute.VirtualMachineScaleSetVMInstanceView, error) {
+	if view, ok := f.instanceViews[resourceGroupName+"/"+VMScaleSetName+"/"+instanceID]; ok {
+		return view, nil
+	}
+	return compute.VirtualMachineScaleSetVMInstanceView{}, fmt.Errorf("instance %s not found", instanceID)
+}
+
+func (f *fakeVirtualMachineScaleSetVMsClient) List(ctx context.Context, resourceGroupName string, virtualMachineScaleSetName string, filter string, selectParameter string, expand string) ([]compute.VirtualMachineScaleSetVM, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+func (f *fakeVirtualMachineScaleSetVMsClient) Update(ctx context.Context, resourceGroupName string, VMScaleSetName string, instanceID string, parameters compute.VirtualMachineScaleSetVM) (*http.Response, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+type fakeInterfacesClient struct {
+	nics map[string]network.Interface
+}
+
+func (f *fakeInterfacesClient) CreateOrUpdate(ctx context.Context, resourceGroupName string, networkInterfaceName string, parameters network.Interface) (*http.Response, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+func (f *fakeInterfacesClient) Get(ctx context.Context, resourceGroupName string, networkInterfaceName string, expand string) (network.Interface, error) {
+	if nic, ok := f.nics[resourceGroupName+"/"+networkInterfaceName]; ok {
+		return nic, nil
+	}
+	return network.Interface{}, fmt.Errorf("nic %s not found", networkInterfaceName)
+}
+
+func (f *fakeInterfacesClient) GetVirtualMachineScaleSetNetworkInterface(ctx context.Context, resourceGroupName string, virtualMachineScaleSetName string, virtualmachineIndex string, networkInterfaceName string, expand string) (network.Interface, error) {
+	if nic, ok := f.nics[resourceGroupName+"/"+virtualMachineScaleSetName+"/"+virtualmachineIndex+"/"+networkInterfaceName]; ok {
+		return nic, nil
+	}
+	return network.Interface{}, fmt.Errorf("nic %s not found", networkInterfaceName)
+}
+
+type fakeVirtualMachineSizesClient struct {
+	calls int
+	sizes []compute.VirtualMachineSize
+}
+
+func (f *fakeVirtualMachineSizesClient) List(ctx context.Context, location string) (compute.VirtualMachineSizeListResult, error) {
+	f.calls++
+	return compute.VirtualMachineSizeListResult{Value: &f.sizes}, nil
+}
+
+func newTestController() *Controller {
+	return &Controller{
+		location: "westus2",
+		virtualMachinesClient: &fakeVirtualMachinesClient{
+			vms: map[string]compute.VirtualMachine{
+				"rg/vm1": {
+					VirtualMachineProperties: &compute.VirtualMachineProperties{
+						HardwareProfile: &compute.HardwareProfile{VMSize: compute.VirtualMachineSizeTypesStandardDS2V2},
+						StorageProfile:  &compute.StorageProfile{OsDisk: &compute.OSDisk{}},
+						InstanceView: &compute.VirtualMachineInstanceView{
+							PlatformFaultDomain:  to.Int32Ptr(1),
+							PlatformUpdateDomain: to.Int32Ptr(3),
+						},
+						NetworkProfile: &compute.NetworkProfile{
+							NetworkInterfaces: &[]compute.NetworkInterfaceReference{
+								{
+									ID:                                  to.StringPtr("/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Network/networkInterfaces/nic2"),
+									NetworkInterfaceReferenceProperties: &compute.NetworkInterfaceReferenceProperties{Primary: to.BoolPtr(false)},
+								},
+								{
+									ID:                                  to.StringPtr("/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Network/networkInterfaces/nic1"),
+									NetworkInterfaceReferenceProperties: &compute.NetworkInterfaceReferenceProperties{Primary: to.BoolPtr(true)},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		virtualMachineScaleSetVMsClient: &fakeVirtualMachineScaleSetVMsClient{
+			vms: map[string]compute.VirtualMachineScaleSetVM{
+				"rg/ss/0": {
+					Sku: &compute.Sku{Name: to.StringPtr("Standard_D4s_v3")},
+					VirtualMachineScaleSetVMProperties: &compute.VirtualMachineScaleSetVMProperties{
+						StorageProfile: &compute.StorageProfile{
+							OsDisk: &compute.OSDisk{DiffDiskSettings: &compute.DiffDiskSettings{Option: compute.Local}},
+						},
+						NetworkProfile: &compute.NetworkProfile{
+							NetworkInterfaces: &[]compute.NetworkInterfaceReference{
+								{
+									ID: to.StringPtr("/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachineScaleSets/ss/virtualMachines/0/networkInterfaces/ss-nic"),
+								},
+							},
+						},
+					},
+				},
+			},
+			instanceViews: map[string]compute.VirtualMachineScaleSetVMInstanceView{
+				"rg/ss/0": {
+					PlatformFaultDomain:  to.Int32Ptr(2),
+					PlatformUpdateDomain: to.Int32Ptr(0),
+				},
+			},
+		},
+		interfacesClient: &fakeInterfacesClient{
+			nics: map[string]network.Interface{
+				"rg/nic1": {
+					InterfacePropertiesFormat: &network.InterfacePropertiesFormat{EnableAcceleratedNetworking: to.BoolPtr(true)},
+				},
+				"rg/ss/0/ss-nic": {
+					InterfacePropertiesFormat: &network.InterfacePropertiesFormat{EnableAcceleratedNetworking: to.BoolPtr(false)},
+				},
+			},
+		},
+		virtualMachineSizesClient: &fakeVirtualMachineSizesClient{
+			sizes: []compute.VirtualMachineSize{
+				{
+					Name:             to.StringPtr("Standard_DS2_v2"),
+					NumberOfCores:    to.Int32Ptr(2),
+					MemoryInMB:       to.Int32Ptr(7168),
+					MaxDataDiskCount: to.Int32Ptr(8),
+				},
+				{
+					Name:             to.StringPtr("Standard_D4s_v3"),
+					NumberOfCores:    to.Int32Ptr(4),
+					MemoryInMB:       to.Int32Ptr(16384),
+					MaxDataDiskCount: to.Int32Ptr(8),
+				},
+			},
+		},
+		isNodeUnmanaged: func(providerID string) bool { return false },
+		queue:           workqueue.NewRateLimitingQueue(workqueue.NewItemExponentialFailureRateLimiter(time.Millisecond, time.Millisecond)),
+		labeledNodes:    make(map[string]string),
+		failedNodes:     make(map[string]string),
+		skippedNodes:    make(map[string]string),
+		vmSizes:         make(map[string]map[string]compute.VirtualMachineSize),
+	}
+}
+
+// newTestControllerWithNodes returns a test controller whose Kubernetes client and node lister hold the nodes.
+func newTestControllerWithNodes(nodes ...*v1.Node) (*Controller, *fake.Clientset) {
+	var objects []runtime.Object
+	for _, node := range nodes {
+		objects = append(objects, node)
+	}
+	kubeClient := fake.NewSimpleClientset(objects...)
+	nodeInformer := informers.NewSharedInformerFactory(kubeClient, 0).Core().V1().Nodes()
+	for _, node := range nodes {
+		nodeInformer.Informer().GetIndexer().Add(node)
+	}
+
+	c := newTestController()
+	c.kubeClient = kubeClient
+	c.nodeLister = nodeInformer.Lister()
+	return c, kubeClient
+}
+
+func newTestNode(name, providerID string, labels map[string]string) *v1.Node {
+	return &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels},
+		Spec:       v1.NodeSpec{ProviderID: providerID},
+	}
+}
+
+func getPatchActions(kubeClient *fake.Clientset) []core.PatchAction {
+	var patches []core.PatchAction
+	for _, action := range kubeClient.Actions() {
+		if patch, ok := action.(core.PatchAction); ok {
+			patches = append(patches, patch)
+		}
+	}
+	return patches
+}
+
+func TestGetInstanceLabels(t *testing.T) {
+	testCases := []struct {
+		desc       string
+		providerID string
+		expected   map[string]string
+		expectErr  bool
+	}{
+		{
+			desc:       "labels of standalone VM should be returned",
+			providerID: "azure:///subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm1",
+			expected: map[string]string{
+				LabelFaultDomain:           "1",
+				LabelUpdateDomain:          "3",
+				LabelAcceleratedNetworking: "true",
+				LabelEphemeralOSDisk:       "false",
+				LabelSKUCPU:                "2",
+				LabelSKUMemory:             "7168",
+				LabelSKUMaxDataDisks:       "8",
+			},
+		},
+		{
+			desc:       "labels of scale set VM should be returned",
+			providerID: "azure:///subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachineScaleSets/ss/virtualMachines/0",
+			expected: map[string]string{
+				LabelFaultDomain:           "2",
+				LabelUpdateDomain:          "0",
+				LabelScaleSet:              "ss",
+				LabelAcceleratedNetworking: "false",
+				LabelEphemeralOSDisk:       "true",
+				LabelSKUCPU:                "4",
+				LabelSKUMemory:             "16384",
+				LabelSKUMaxDataDisks:       "8",
+			},
+		},
+		{
+			desc:       "error should be returned for nonexistent VM",
+			providerID: "azure:///subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm2",
+			expectErr:  true,
+		},
+		{
+			desc:       "error should be returned for invalid provider ID",
+			providerID: "aws:///us-east-1a/i-0123456789",
+			expectErr:  true,
+		},
+	}
+
+	for _, test := range testCases {
+		c := newTestController()
+		info, err := c.getInstanceInfo(test.providerID)
+		if test.expectErr {
+			assert.Error(t, err, test.desc)
+			continue
+		}
+		assert.NoError(t, err, test.desc)
+
+		size, err := c.getVMSize(info.vmSize)
+		assert.NoError(t, err, test.desc)
+		assert.Equal(t, test.expected, getNodeLabels(info, size), test.desc)
+	}
+}
+
+func TestGetVMSizeCached(t *testing.T) {
+	c := newTestController()
+	sizesClient := c.virtualMachineSizesClient.(*fakeVirtualMachineSizesClient)
+
+	size, err := c.getVMSize("standard_ds2_v2")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2), *size.NumberOfCores)
+
+	size, err = c.getVMSize("Standard_Unknown")
+	assert.NoError(t, err)
+	assert.Nil(t, size)
+	assert.Equal(t, 1, sizesClient.calls)
+}
+
+func TestGetNodeLabelsWithoutSize(t *testing.T) {
+	labels := getNodeLabels(&instanceInfo{faultDomain: to.Int32Ptr(0)}, nil)
+	assert.Equal(t, map[string]string{
+		LabelFaultDomain:     "0",
+		LabelEphemeralOSDisk: "false",
+	}, labels)
+}
+
+func TestSyncNode(t *testing.T) {
+	vmLabels := map[string]string{
+		LabelFaultDomain:           "1",
+		LabelUpdateDomain:          "3",
+		LabelAcceleratedNetworking: "true",
+		LabelEphemeralOSDisk:       "false",
+		LabelSKUCPU:                "2",
+		LabelSKUMemory:             "7168",
+		LabelSKUMaxDataDisks:       "8",
+	}
+
+	testCases := []struct {
+		desc            string
+		node            *v1.Node
+		patchErr        error
+		expectedPatch   map[string]interface{}
+		expectedLabeled bool
+		expectErr       bool
+	}{
+		{
+			desc: "changed labels should be patched and stale labels should be removed",
+			node: newTestNode("node1", testVMProviderID, map[string]string{
+				LabelScaleSet:     "old-ss",
+				LabelFaultDomain:  "0",
+				LabelUpdateDomain: "3",
+				"foo":             "bar",
+			}),
+			expectedPatch: map[string]interface{}{
+				LabelScaleSet:              nil,
+				LabelFaultDomain:           "1",
+				LabelAcceleratedNetworking: "true",
+				LabelEphemeralOSDisk:       "false",
+				LabelSKUCPU:                "2",
+				LabelSKUMemory:             "7168",
+				LabelSKUMaxDataDisks:       "8",
+			},
+			expectedLabeled: true,
+		},
+		{
+			desc:            "node with up to date labels should not be patched",
+			node:            newTestNode("node1", testVMProviderID, vmLabels),
+			expectedLabeled: true,
+		},
+		{
+			desc: "node without provider ID should be skipped",
+			node: newTestNode("node1", "", nil),
+		},
+		{
+			desc: "unmanaged node should be skipped",
+			node: newTestNode("node1", testVMProviderID, map[string]string{managedByAzureLabel: "false"}),
+		},
+		{
+			desc:      "error should be returned if the VM is not found",
+			node:      newTestNode("node1", testMissingProviderID, nil),
+			expectErr: true,
+		},
+		{
+			desc:     "error should be returned if the node can't be patched",
+			node:     newTestNode("node1", testVMProviderID, nil),
+			patchErr: fmt.Errorf("conflict"),
+			expectedPatch: map[string]interface{}{
+				LabelFaultDomain:           "1",
+				LabelUpdateDomain:          "3",
+				LabelAcceleratedNetworking: "true",
+				LabelEphemeralOSDisk:       "false",
+				LabelSKUCPU:                "2",
+				LabelSKUMemory:             "7168",
+				LabelSKUMaxDataDisks:       "8",
+			},
+			expectErr: true,
+		},
+	}
+
+	for _, test := range testCases {
+		c, kubeClient := newTestControllerWithNodes(test.node)
+		if test.patchErr != nil {
+			kubeClient.PrependReactor("patch", "nodes", func(action core.Action) (bool, runtime.Object, error) {
+				return true, nil, test.patchErr
+			})
+		}
+
+		err := c.syncNode(test.node.Name)
+		if test.expectErr {
+			assert.Error(t, err, test.desc)
+		} else {
+			assert.NoError(t, err, test.desc)
+		}
+
+		patches := getPatchActions(kubeClient)
+		if test.expectedPatch == nil {
+			assert.Empty(t, patches, test.desc)
+		} else if assert.Len(t, patches, 1, test.desc) {
+			assert.Equal(t, "node1", patches[0].GetName(), test.desc)
+			patch := struct {
+				Metadata struct {
+					Labels map[string]interface{} `json:"labels"`
+				} `json:"metadata"`
+			}{}
+			assert.NoError(t, json.Unmarshal(patches[0].GetPatch(), &patch), test.desc)
+			assert.Equal(t, test.expectedPatch, patch.Metadata.Labels, test.desc)
+		}
+		_, labeled := c.labeledNodes[test.node.Name]
+		assert.Equal(t, test.expectedLabeled, labeled, test.desc)
+	}
+}
+
+func TestFailedNodeIsNotRequeuedByUpdates(t *testing.T) {
+	node := newTestNode("node1", testMissingProviderID, nil)
+	c, kubeClient := newTestControllerWithNodes(node)
+
+	c.enqueueNode(node)
+	assert.True(t, c.processNextWorkItem())
+	// The node is waiting for a rate limited retry.
+	assert.False(t, c.needsSync(node))
+
+	for i := 0; i < maxRetries; i++ {
+		assert.True(t, c.processNextWorkItem())
+	}
+	assert.Equal(t, 0, c.queue.Len())
+	assert.Equal(t, map[string]string{"node1": testMissingProviderID}, c.failedNodes)
+	assert.Empty(t, getPatchActions(kubeClient))
+
+	// Heartbeats don't enqueue the failed node again, but a new provider ID does.
+	assert.False(t, c.needsSync(node))
+	assert.True(t, c.needsSync(newTestNode("node1", testVMProviderID, nil)))
+
+	// The failed node is labeled by the periodical relabeling once the VM is found.
+	c.virtualMachinesClient.(*fakeVirtualMachinesClient).vms["rg/vm2"] = c.virtualMachinesClient.(*fakeVirtualMachinesClient).vms["rg/vm1"]
+	c.resync()
+	assert.True(t, c.processNextWorkItem())
+	assert.Empty(t, c.failedNodes)
+	assert.Equal(t, map[string]string{"node1": testMissingProviderID}, c.labeledNodes)
+	assert.Len(t, getPatchActions(kubeClient), 1)
+	assert.False(t, c.needsSync(node))
+}
+
+func TestUnchangedNodeIsNotRequeuedByUpdates(t *testing.T) {
+	testCases := []struct {
+		desc            string
+		node            *v1.Node
+		updated         *v1.Node
+		expectedSkipped bool
+	}{
+		{
+			desc:    "labeled node should be enqueued again only if its provider ID changes",
+			node:    newTestNode("node1", testVMProviderID, nil),
+			updated: newTestNode("node1", testMissingProviderID, nil),
+		},
+		{
+			desc:            "node without provider ID should be enqueued again only once it's set",
+			node:            newTestNode("node1", "", nil),
+			updated:         newTestNode("node1", testVMProviderID, nil),
+			expectedSkipped: true,
+		},
+		{
+			desc:            "node labeled as unmanaged should be enqueued again only if the label changes",
+			node:            newTestNode("node1", testVMProviderID, map[string]string{managedByAzureLabel: "false"}),
+			updated:         newTestNode("node1", testVMProviderID, map[string]string{managedByAzureLabel: "true"}),
+			expectedSkipped: true,
+		},
+		{
+			desc:            "node with unmanaged provider ID should be enqueued again only if its provider ID changes",
+			node:            newTestNode("node1", "aws:///us-east-1a/i-0123456789", nil),
+			updated:         newTestNode("node1", testVMProviderID, nil),
+			expectedSkipped: true,
+		},
+	}
+
+	for _, test := range testCases {
+		c, _ := newTestControllerWithNodes(test.node)
+		c.isNodeUnmanaged = func(providerID string) bool {
+			return providerID != testVMProviderID && providerID != testMissingProviderID
+		}
+
+		c.enqueueNode(test.node)
+		assert.True(t, c.processNextWorkItem(), test.desc)
+		assert.Equal(t, 0, c.queue.Len(), test.desc)
+		if test.expectedSkipped {
+			assert.Contains(t, c.skippedNodes, "node1", test.desc)
+		} else {
+			assert.Contains(t, c.labeledNodes, "node1", test.desc)
+		}
+
+		// A heartbeat only changes the node status.
+		heartbeat := test.node.DeepCopy()
+		heartbeat.Status.Conditions = []v1.NodeCondition{{Type: v1.NodeReady, Status: v1.ConditionTrue, LastHeartbeatTime: metav1.Now()}}
+		c.updateNode(test.node, heartbeat)
+		assert.Equal(t, 0, c.queue.Len(), test.desc)
+
+		c.updateNode(heartbeat, test.updated)
+		assert.Equal(t, 1, c.queue.Len(), test.desc)
+	}
+}
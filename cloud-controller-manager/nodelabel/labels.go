@@ -0,0 +1,106 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nodelabel
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2018-10-01/compute"
+)
+
+const (
+	// LabelFaultDomain is the platform fault domain of the node's virtual machine.
+	LabelFaultDomain = "kubernetes.azure.com/fault-domain"
+	// LabelUpdateDomain is the platform update domain of the node's virtual machine.
+	LabelUpdateDomain = "kubernetes.azure.com/update-domain"
+	// LabelScaleSet is the name of the virtual machine scale set the node belongs to.
+	LabelScaleSet = "kubernetes.azure.com/scale-set"
+	// LabelAcceleratedNetworking is set to "true" if the node's primary network interface has accelerated networking enabled.
+	LabelAcceleratedNetworking = "kubernetes.azure.com/accelerated-networking"
+	// LabelEphemeralOSDisk is set to "true" if the node's OS disk is an ephemeral (local) disk.
+	LabelEphemeralOSDisk = "kubernetes.azure.com/ephemeral-os-disk"
+	// LabelSKUCPU is the number of vCPUs of the node's VM size.
+	LabelSKUCPU = "kubernetes.azure.com/sku-cpu"
+	// LabelSKUMemory is the amount of memory in MiB of the node's VM size.
+	LabelSKUMemory = "kubernetes.azure.com/sku-memory"
+	// LabelSKUMaxDataDisks is the maximum number of data disks that could be attached to the node's VM size.
+	LabelSKUMaxDataDisks = "kubernetes.azure.com/sku-max-data-disks"
+)
+
+// managedLabels are all the labels managed by the node label controller.
+var managedLabels = []string{
+	LabelFaultDomain,
+	LabelUpdateDomain,
+	LabelScaleSet,
+	LabelAcceleratedNetworking,
+	LabelEphemeralOSDisk,
+	LabelSKUCPU,
+	LabelSKUMemory,
+	LabelSKUMaxDataDisks,
+}
+
+// instanceInfo holds the properties of a node's virtual machine which are exposed as labels.
+type instanceInfo struct {
+	faultDomain           *int32
+	updateDomain          *int32
+	scaleSetName          string
+	vmSize                string
+	acceleratedNetworking *bool
+	ephemeralOSDisk       bool
+}
+
+// getNodeLabels returns the desired labels for an instance. size could be nil if the VM size is unknown.
+func getNodeLabels(info *instanceInfo, size *compute.VirtualMachineSize) map[string]string {
+	labels := make(map[string]string)
+	if info.faultDomain != nil {
+		labels[LabelFaultDomain] = strconv.Itoa(int(*info.faultDomain))
+	}
+	if info.updateDomain != nil {
+		labels[LabelUpdateDomain] = strconv.Itoa(int(*info.updateDomain))
+	}
+	if info.scaleSetName != "" {
+		labels[LabelScaleSet] = strings.ToLower(info.scaleSetName)
+	}
+	if info.acceleratedNetworking != nil {
+		labels[LabelAcceleratedNetworking] = strconv.FormatBool(*info.acceleratedNetworking)
+	}
+	labels[LabelEphemeralOSDisk] = strconv.FormatBool(info.ephemeralOSDisk)
+
+	if size != nil {
+		if size.NumberOfCores != nil {
+			labels[LabelSKUCPU] = strconv.Itoa(int(*size.NumberOfCores))
+		}
+		if size.MemoryInMB != nil {
+			labels[LabelSKUMemory] = strconv.Itoa(int(*size.MemoryInMB))
+		}
+		if size.MaxDataDiskCount != nil {
+			labels[LabelSKUMaxDataDisks] = strconv.Itoa(int(*size.MaxDataDiskCount))
+		}
+	}
+
+	return labels
+}
+
+// isEphemeralOSDisk returns true if the OS disk is placed on the VM's local storage.
+func isEphemeralOSDisk(storageProfile *compute.StorageProfile) bool {
+	if storageProfile == nil || storageProfile.OsDisk == nil || storageProfile.OsDisk.DiffDiskSettings == nil {
+		return false
+	}
+
+	return storageProfile.OsDisk.DiffDiskSettings.Option == compute.Local
+}
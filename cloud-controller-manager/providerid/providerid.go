@@ -0,0 +1,188 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package providerid parses and formats the provider IDs of Azure nodes, e.g.
+//
+//	azure:///subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm
+//	azure:///subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachineScaleSets/ss/virtualMachines/0
+//
+// VMs in availability sets have the same provider IDs as standalone VMs.
+package providerid
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// Prefix is the prefix of all Azure provider IDs.
+	Prefix = "azure://"
+
+	computeProvider = "Microsoft.Compute"
+)
+
+// Kind is the kind of the VM identified by a provider ID.
+type Kind string
+
+const (
+	// KindVirtualMachine is a standalone VM or a VM in an availability set.
+	KindVirtualMachine Kind = "VirtualMachine"
+	// KindScaleSetVM is a VM instance of a virtual machine scale set.
+	KindScaleSetVM Kind = "ScaleSetVM"
+)
+
+// ProviderID is a parsed Azure provider ID.
+type ProviderID struct {
+	SubscriptionID string
+	ResourceGroup  string
+	// ScaleSetName is empty for standalone VMs.
+	ScaleSetName string
+	// Name is the VM name for standalone VMs, or the instance ID for scale set VMs.
+	Name string
+}
+
+// NewVirtualMachine returns the provider ID of a standalone VM.
+func NewVirtualMachine(subscriptionID, resourceGroup, name string) *ProviderID {
+	return &ProviderID{
+		SubscriptionID: subscriptionID,
+		ResourceGroup:  resourceGroup,
+		Name:           name,
+	}
+}
+
+// NewScaleSetVM returns the provider ID of a scale set VM.
+func NewScaleSetVM(subscriptionID, resourceGroup, scaleSetName, instanceID string) *ProviderID {
+	return &ProviderID{
+		SubscriptionID: subscriptionID,
+		ResourceGroup:  resourceGroup,
+		ScaleSetName:   scaleSetName,
+		Name:           instanceID,
+	}
+}
+
+// Parse parses the provider ID. Segment names (e.g. resourceGroups) are matched
+// case-insensitively, and resource names keep their original case.
+func Parse(providerID string) (*ProviderID, error) {
+	if !strings.HasPrefix(providerID, Prefix) {
+		return nil, fmt.Errorf("invalid provider ID %q: missing prefix %q", providerID, Prefix)
+	}
+
+	segments := strings.Split(strings.TrimPrefix(providerID, Prefix), "/")
+	// The resource ID after the prefix starts with "/", so the first segment is empty.
+	if segments[0] != "" {
+		return nil, fmt.Errorf("invalid provider ID %q: resource ID should start with \"/\"", providerID)
+	}
+	segments = segments[1:]
+
+	var (
+		keys   []string
+		values []string
+	)
+	for i := 0; i < len(segments); i += 2 {
+		if i+1 >= len(segments) {
+			return nil, fmt.Errorf("invalid provider ID %q: missing value of %q", providerID, segments[i])
+		}
+		if segments[i] == "" || segments[i+1] == "" {
+			return nil, fmt.Errorf("invalid provider ID %q: empty segment", providerID)
+		}
+		keys = append(keys, segments[i])
+		values = append(values, segments[i+1])
+	}
+
+	// subscriptions/{sub}/resourceGroups/{rg}/providers/Microsoft.Compute/ followed by
+	// virtualMachines/{vm} or virtualMachineScaleSets/{ss}/virtualMachines/{id}.
+	var expectedKeys []string
+	switch len(keys) {
+	case 4:
+		expectedKeys = []string{"subscriptions", "resourceGroups", "providers", "virtualMachines"}
+	case 5:
+		expectedKeys = []string{"subscriptions", "resourceGroups", "providers", "virtualMachineScaleSets", "virtualMachines"}
+	default:
+		return nil, fmt.Errorf("invalid provider ID %q: expected a VM or a scale set VM resource ID", providerID)
+	}
+	for i, key := range expectedKeys {
+		if !strings.EqualFold(keys[i], key) {
+			return nil, fmt.Errorf("invalid provider ID %q: expected %q, got %q", providerID, key, keys[i])
+		}
+	}
+	if !strings.EqualFold(values[2], computeProvider) {
+		return nil, fmt.Errorf("invalid provider ID %q: expected provider %q, got %q", providerID, computeProvider, values[2])
+	}
+
+	id := &ProviderID{
+		SubscriptionID: values[0],
+		ResourceGroup:  values[1],
+		Name:           values[len(values)-1],
+	}
+	if len(values) == 5 {
+		id.ScaleSetName = values[3]
+	}
+	return id, nil
+}
+
+// Kind returns the kind of the VM.
+func (p *ProviderID) Kind() Kind {
+	if p.ScaleSetName != "" {
+		return KindScaleSetVM
+	}
+	return KindVirtualMachine
+}
+
+// IsScaleSetVM returns true if the provider ID is of a scale set VM.
+func (p *ProviderID) IsScaleSetVM() bool {
+	return p.Kind() == KindScaleSetVM
+}
+
+// ResourceID returns the ARM resource ID of the VM.
+func (p *ProviderID) ResourceID() string {
+	if p.IsScaleSetVM() {
+		return fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/%s/virtualMachineScaleSets/%s/virtualMachines/%s",
+			p.SubscriptionID, p.ResourceGroup, computeProvider, p.ScaleSetName, p.Name)
+	}
+	return fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/%s/virtualMachines/%s",
+		p.SubscriptionID, p.ResourceGroup, computeProvider, p.Name)
+}
+
+// String returns the provider ID. Parse(p.String()) returns a ProviderID equal to p.
+func (p *ProviderID) String() string {
+	return Prefix + p.ResourceID()
+}
+
+// Normalize returns a copy of p with the resource group in lower case. Resource
+// group names are case-insensitive, and ARM may return them in different cases.
+func (p *ProviderID) Normalize() *ProviderID {
+	normalized := *p
+	normalized.ResourceGroup = strings.ToLower(p.ResourceGroup)
+	return &normalized
+}
+
+// Equal returns true if both provider IDs identify the same VM. Azure resource
+// names are case-insensitive, so all fields are compared case-insensitively.
+func (p *ProviderID) Equal(other *ProviderID) bool {
+	return strings.EqualFold(p.SubscriptionID, other.SubscriptionID) &&
+		strings.EqualFold(p.ResourceGroup, other.ResourceGroup) &&
+		strings.EqualFold(p.ScaleSetName, other.ScaleSetName) &&
+		strings.EqualFold(p.Name, other.Name)
+}
+
+// Normalize parses the provider ID and formats it with the resource group in lower case.
+func Normalize(providerID string) (string, error) {
+	id, err := Parse(providerID)
+	if err != nil {
+		return "", err
+	}
+	return id.Normalize().String(), nil
+}
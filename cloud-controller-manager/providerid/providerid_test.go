@@ -0,0 +1,206 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package providerid
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	testCases := []struct {
+		desc       string
+		providerID string
+		expected   *ProviderID
+		expectErr  bool
+	}{
+		{
+			desc:       "standalone VM should be parsed",
+			providerID: "azure:///subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm1",
+			expected:   NewVirtualMachine("sub", "rg", "vm1"),
+		},
+		{
+			desc:       "scale set VM should be parsed",
+			providerID: "azure:///subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachineScaleSets/ss/virtualMachines/0",
+			expected:   NewScaleSetVM("sub", "rg", "ss", "0"),
+		},
+		{
+			desc:       "segment names should be matched case-insensitively and resource names should keep their case",
+			providerID: "azure:///Subscriptions/sub/resourcegroups/MC_RG_cluster_westus2/Providers/microsoft.compute/VirtualMachineScaleSets/SS/VirtualMachines/12",
+			expected:   NewScaleSetVM("sub", "MC_RG_cluster_westus2", "SS", "12"),
+		},
+		{
+			desc:       "empty provider ID should be rejected",
+			providerID: "",
+			expectErr:  true,
+		},
+		{
+			desc:       "provider ID of other cloud should be rejected",
+			providerID: "aws:///us-east-1a/i-0123456789",
+			expectErr:  true,
+		},
+		{
+			desc:       "provider ID without leading slash should be rejected",
+			providerID: "azure://subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm1",
+			expectErr:  true,
+		},
+		{
+			desc:       "provider ID with missing VM name should be rejected",
+			providerID: "azure:///subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines",
+			expectErr:  true,
+		},
+		{
+			desc:       "provider ID with empty resource group should be rejected",
+			providerID: "azure:///subscriptions/sub/resourceGroups//providers/Microsoft.Compute/virtualMachines/vm1",
+			expectErr:  true,
+		},
+		{
+			desc:       "provider ID with trailing slash should be rejected",
+			providerID: "azure:///subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm1/",
+			expectErr:  true,
+		},
+		{
+			desc:       "provider ID of non-compute resource should be rejected",
+			providerID: "azure:///subscriptions/sub/resourceGroups/rg/providers/Microsoft.Network/virtualMachines/vm1",
+			expectErr:  true,
+		},
+		{
+			desc:       "provider ID of availability set should be rejected",
+			providerID: "azure:///subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/availabilitySets/as1",
+			expectErr:  true,
+		},
+		{
+			desc:       "provider ID of scale set should be rejected",
+			providerID: "azure:///subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachineScaleSets/ss",
+			expectErr:  true,
+		},
+		{
+			desc:       "provider ID with unexpected segment should be rejected",
+			providerID: "azure:///subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/availabilitySets/as1/virtualMachines/vm1",
+			expectErr:  true,
+		},
+	}
+
+	for _, test := range testCases {
+		id, err := Parse(test.providerID)
+		if test.expectErr {
+			assert.Error(t, err, test.desc)
+			continue
+		}
+		assert.NoError(t, err, test.desc)
+		assert.Equal(t, test.expected, id, test.desc)
+
+		// Formatting and parsing again should give the same provider ID.
+		roundTrip, err := Parse(id.String())
+		assert.NoError(t, err, test.desc)
+		assert.Equal(t, id, roundTrip, test.desc)
+	}
+}
+
+func TestString(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		id       *ProviderID
+		kind     Kind
+		expected string
+	}{
+		{
+			desc:     "standalone VM should be formatted",
+			id:       NewVirtualMachine("sub", "rg", "vm1"),
+			kind:     KindVirtualMachine,
+			expected: "azure:///subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm1",
+		},
+		{
+			desc:     "scale set VM should be formatted",
+			id:       NewScaleSetVM("sub", "RG", "ss", "3"),
+			kind:     KindScaleSetVM,
+			expected: "azure:///subscriptions/sub/resourceGroups/RG/providers/Microsoft.Compute/virtualMachineScaleSets/ss/virtualMachines/3",
+		},
+	}
+
+	for _, test := range testCases {
+		assert.Equal(t, test.expected, test.id.String(), test.desc)
+		assert.Equal(t, test.kind, test.id.Kind(), test.desc)
+		assert.Equal(t, test.kind == KindScaleSetVM, test.id.IsScaleSetVM(), test.desc)
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	testCases := []struct {
+		desc       string
+		providerID string
+		expected   string
+		expectErr  bool
+	}{
+		{
+			desc:       "resource group should be lower cased",
+			providerID: "azure:///subscriptions/sub/resourceGroups/MC_RG/providers/Microsoft.Compute/virtualMachines/VM1",
+			expected:   "azure:///subscriptions/sub/resourceGroups/mc_rg/providers/Microsoft.Compute/virtualMachines/VM1",
+		},
+		{
+			desc:       "segment names should be formatted in canonical case",
+			providerID: "azure:///subscriptions/sub/resourcegroups/rg/providers/microsoft.compute/virtualmachinescalesets/ss/virtualmachines/0",
+			expected:   "azure:///subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachineScaleSets/ss/virtualMachines/0",
+		},
+		{
+			desc:       "invalid provider ID should be rejected",
+			providerID: "azure:///subscriptions/sub",
+			expectErr:  true,
+		},
+	}
+
+	for _, test := range testCases {
+		normalized, err := Normalize(test.providerID)
+		if test.expectErr {
+			assert.Error(t, err, test.desc)
+			continue
+		}
+		assert.NoError(t, err, test.desc)
+		assert.Equal(t, test.expected, normalized, test.desc)
+	}
+}
+
+func TestEqual(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		a, b     *ProviderID
+		expected bool
+	}{
+		{
+			desc:     "provider IDs differing only in case should be equal",
+			a:        NewScaleSetVM("SUB", "MC_RG", "SS", "0"),
+			b:        NewScaleSetVM("sub", "mc_rg", "ss", "0"),
+			expected: true,
+		},
+		{
+			desc: "standalone VM and scale set VM should not be equal",
+			a:    NewVirtualMachine("sub", "rg", "0"),
+			b:    NewScaleSetVM("sub", "rg", "ss", "0"),
+		},
+		{
+			desc: "VMs in different resource groups should not be equal",
+			a:    NewVirtualMachine("sub", "rg1", "vm"),
+			b:    NewVirtualMachine("sub", "rg2", "vm"),
+		},
+	}
+
+	for _, test := range testCases {
+		assert.Equal(t, test.expected, test.a.Equal(test.b), test.desc)
+		assert.Equal(t, test.expected, test.b.Equal(test.a), test.desc)
+	}
+}
@@ -0,0 +1,110 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package reconcile measures the durations and results of the load balancer and route
+// operations, which the controllers of the cloud controller manager call on the Azure cloud
+// provider to reconcile services and nodes.
+package reconcile
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/api/core/v1"
+	cloudprovider "k8s.io/cloud-provider"
+)
+
+const (
+	resultSucceeded = "succeeded"
+	resultFailed    = "failed"
+)
+
+var operationDuration = registerOperationMetrics()
+
+func registerOperationMetrics() *prometheus.HistogramVec {
+	duration := prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "cloudprovider_azure_operation_duration_seconds",
+			Help:    "Duration of a load balancer or route operation of the Azure cloud provider, by result",
+			Buckets: []float64{0.1, 0.5, 1, 5, 10, 30, 60, 120, 300, 600},
+		},
+		[]string{"operation", "result"},
+	)
+	prometheus.MustRegister(duration)
+	return duration
+}
+
+// observe records the duration of the operation since start, and whether it failed.
+func observe(operation string, start time.Time, err error) {
+	result := resultSucceeded
+	if err != nil {
+		result = resultFailed
+	}
+	operationDuration.WithLabelValues(operation, result).Observe(time.Since(start).Seconds())
+}
+
+// LoadBalancer measures the operations of the load balancer interface which change load
+// balancers. The others are not measured.
+type LoadBalancer struct {
+	cloudprovider.LoadBalancer
+}
+
+// EnsureLoadBalancer creates or updates the load balancer of the service.
+func (lb *LoadBalancer) EnsureLoadBalancer(ctx context.Context, clusterName string, service *v1.Service, nodes []*v1.Node) (*v1.LoadBalancerStatus, error) {
+	start := time.Now()
+	status, err := lb.LoadBalancer.EnsureLoadBalancer(ctx, clusterName, service, nodes)
+	observe("ensure_load_balancer", start, err)
+	return status, err
+}
+
+// UpdateLoadBalancer updates the backend pools of the load balancer of the service.
+func (lb *LoadBalancer) UpdateLoadBalancer(ctx context.Context, clusterName string, service *v1.Service, nodes []*v1.Node) error {
+	start := time.Now()
+	err := lb.LoadBalancer.UpdateLoadBalancer(ctx, clusterName, service, nodes)
+	observe("update_load_balancer", start, err)
+	return err
+}
+
+// EnsureLoadBalancerDeleted deletes the load balancer resources of the service.
+func (lb *LoadBalancer) EnsureLoadBalancerDeleted(ctx context.Context, clusterName string, service *v1.Service) error {
+	start := time.Now()
+	err := lb.LoadBalancer.EnsureLoadBalancerDeleted(ctx, clusterName, service)
+	observe("ensure_load_balancer_deleted", start, err)
+	return err
+}
+
+// Routes measures the operations of the routes interface which change routes. ListRoutes
+// is not measured.
+type Routes struct {
+	cloudprovider.Routes
+}
+
+// CreateRoute creates the route of a node.
+func (r *Routes) CreateRoute(ctx context.Context, clusterName string, nameHint string, route *cloudprovider.Route) error {
+	start := time.Now()
+	err := r.Routes.CreateRoute(ctx, clusterName, nameHint, route)
+	observe("create_route", start, err)
+	return err
+}
+
+// DeleteRoute deletes the route of a node.
+func (r *Routes) DeleteRoute(ctx context.Context, clusterName string, route *cloudprovider.Route) error {
+	start := time.Now()
+	err := r.Routes.DeleteRoute(ctx, clusterName, route)
+	observe("delete_route", start, err)
+	return err
+}
@@ -0,0 +1,123 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reconcile
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/api/core/v1"
+	cloudprovider "k8s.io/cloud-provider"
+)
+
+type fakeLoadBalancer struct {
+	cloudprovider.LoadBalancer
+	err error
+}
+
+func (f *fakeLoadBalancer) EnsureLoadBalancer(ctx context.Context, clusterName string, service *v1.Service, nodes []*v1.Node) (*v1.LoadBalancerStatus, error) {
+	return &v1.LoadBalancerStatus{}, f.err
+}
+
+func (f *fakeLoadBalancer) UpdateLoadBalancer(ctx context.Context, clusterName string, service *v1.Service, nodes []*v1.Node) error {
+	return f.err
+}
+
+func (f *fakeLoadBalancer) EnsureLoadBalancerDeleted(ctx context.Context, clusterName string, service *v1.Service) error {
+	return f.err
+}
+
+type fakeRoutes struct {
+	cloudprovider.Routes
+	err error
+}
+
+func (f *fakeRoutes) CreateRoute(ctx context.Context, clusterName string, nameHint string, route *cloudprovider.Route) error {
+	return f.err
+}
+
+func (f *fakeRoutes) DeleteRoute(ctx context.Context, clusterName string, route *cloudprovider.Route) error {
+	return f.err
+}
+
+func getSampleCount(t *testing.T, operation, result string) uint64 {
+	metric := &dto.Metric{}
+	assert.NoError(t, operationDuration.WithLabelValues(operation, result).(prometheus.Histogram).Write(metric))
+	return metric.GetHistogram().GetSampleCount()
+}
+
+func TestOperationsAreObserved(t *testing.T) {
+	ctx := context.Background()
+	testCases := []struct {
+		desc      string
+		operation string
+		call      func(lb *LoadBalancer, routes *Routes) error
+	}{
+		{
+			desc:      "EnsureLoadBalancer should be observed",
+			operation: "ensure_load_balancer",
+			call: func(lb *LoadBalancer, routes *Routes) error {
+				_, err := lb.EnsureLoadBalancer(ctx, "cluster", &v1.Service{}, nil)
+				return err
+			},
+		},
+		{
+			desc:      "UpdateLoadBalancer should be observed",
+			operation: "update_load_balancer",
+			call: func(lb *LoadBalancer, routes *Routes) error {
+				return lb.UpdateLoadBalancer(ctx, "cluster", &v1.Service{}, nil)
+			},
+		},
+		{
+			desc:      "EnsureLoadBalancerDeleted should be observed",
+			operation: "ensure_load_balancer_deleted",
+			call: func(lb *LoadBalancer, routes *Routes) error {
+				return lb.EnsureLoadBalancerDeleted(ctx, "cluster", &v1.Service{})
+			},
+		},
+		{
+			desc:      "CreateRoute should be observed",
+			operation: "create_route",
+			call: func(lb *LoadBalancer, routes *Routes) error {
+				return routes.CreateRoute(ctx, "cluster", "node1", &cloudprovider.Route{})
+			},
+		},
+		{
+			desc:      "DeleteRoute should be observed",
+			operation: "delete_route",
+			call: func(lb *LoadBalancer, routes *Routes) error {
+				return routes.DeleteRoute(ctx, "cluster", &cloudprovider.Route{})
+			},
+		},
+	}
+
+	for _, test := range testCases {
+		succeeded := getSampleCount(t, test.operation, resultSucceeded)
+		failed := getSampleCount(t, test.operation, resultFailed)
+
+		assert.NoError(t, test.call(&LoadBalancer{&fakeLoadBalancer{}}, &Routes{&fakeRoutes{}}), test.desc)
+		assert.Equal(t, succeeded+1, getSampleCount(t, test.operation, resultSucceeded), test.desc)
+
+		err := fmt.Errorf("failed")
+		assert.Equal(t, err, test.call(&LoadBalancer{&fakeLoadBalancer{err: err}}, &Routes{&fakeRoutes{err: err}}), test.desc)
+		assert.Equal(t, failed+1, getSampleCount(t, test.operation, resultFailed), test.desc)
+	}
+}
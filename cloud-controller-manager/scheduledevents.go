@@ -0,0 +1,100 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	clientset "k8s.io/client-go/kubernetes"
+	restclient "k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/cloud-provider-azure/cloud-controller-manager/imds"
+	"k8s.io/cloud-provider-azure/cloud-controller-manager/scheduledevents"
+)
+
+// scheduledEventsOptions holds the options of the scheduled events agent.
+type scheduledEventsOptions struct {
+	NodeName     string
+	Master       string
+	Kubeconfig   string
+	PollInterval time.Duration
+	Acknowledge  bool
+	DrainTimeout time.Duration
+}
+
+// newScheduledEventsCommand returns the command which runs the scheduled events agent on a node.
+func newScheduledEventsCommand() *cobra.Command {
+	o := &scheduledEventsOptions{
+		PollInterval: 5 * time.Second,
+		DrainTimeout: 10 * time.Minute,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "scheduled-events",
+		Short: "Run the agent which marks the node ahead of Azure scheduled events",
+		Long: `The scheduled events agent runs on each node (e.g. as a DaemonSet). It polls the scheduled
+events from Azure instance metadata service, and marks the node with a taint and a condition
+ahead of Preempt, Reboot, Redeploy and Freeze events. If acknowledging is enabled, the node
+is drained and the events are acknowledged so that they could start immediately.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := runScheduledEventsAgent(o, wait.NeverStop); err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+				os.Exit(1)
+			}
+		},
+	}
+
+	fs := cmd.Flags()
+	fs.StringVar(&o.NodeName, "node-name", o.NodeName, "Name of the node the agent is running on.")
+	fs.StringVar(&o.Master, "master", o.Master, "The address of the Kubernetes API server (overrides any value in kubeconfig).")
+	fs.StringVar(&o.Kubeconfig, "kubeconfig", o.Kubeconfig, "Path to kubeconfig file with authorization and master location information. In-cluster config is used if not set.")
+	fs.DurationVar(&o.PollInterval, "poll-interval", o.PollInterval, "The interval at which scheduled events are polled.")
+	fs.BoolVar(&o.Acknowledge, "acknowledge", o.Acknowledge, "Drain the node and acknowledge the scheduled events, so that they start before their NotBefore time.")
+	fs.DurationVar(&o.DrainTimeout, "drain-timeout", o.DrainTimeout, "The maximum time to wait for the pods to be evicted before acknowledging the events.")
+
+	return cmd
+}
+
+func runScheduledEventsAgent(o *scheduledEventsOptions, stopCh <-chan struct{}) error {
+	if o.NodeName == "" {
+		return fmt.Errorf("--node-name is required")
+	}
+
+	restConfig, err := clientcmd.BuildConfigFromFlags(o.Master, o.Kubeconfig)
+	if err != nil {
+		return err
+	}
+	kubeClient, err := clientset.NewForConfig(restclient.AddUserAgent(restConfig, "azure-scheduled-events-agent"))
+	if err != nil {
+		return err
+	}
+
+	agent := scheduledevents.NewAgent(
+		o.NodeName,
+		kubeClient,
+		imds.NewClient(imds.MetadataEndpoint, imds.AttestationOptions{}),
+		scheduledevents.NewClient(scheduledevents.ScheduledEventsURL),
+		o.Acknowledge,
+		o.DrainTimeout)
+	agent.Run(o.PollInterval, stopCh)
+	return nil
+}
@@ -0,0 +1,412 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduledevents
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/api/core/v1"
+	policy "k8s.io/api/policy/v1beta1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/cloud-provider-azure/cloud-controller-manager/imds"
+	"k8s.io/klog"
+	"k8s.io/kubernetes/pkg/controller"
+	nodeutil "k8s.io/kubernetes/pkg/util/node"
+)
+
+const (
+	// TaintKeyScheduledEvent is the key of the taint added to nodes with pending scheduled events.
+	// The value of the taint is the event type.
+	TaintKeyScheduledEvent = "kubernetes.azure.com/scheduled-event"
+	// NodeConditionScheduledEvent is the node condition which is true when the node has pending scheduled events.
+	NodeConditionScheduledEvent v1.NodeConditionType = "AzureScheduledEvent"
+
+	eventStatusScheduled = "Scheduled"
+	mirrorPodAnnotation  = "kubernetes.io/config.mirror"
+	drainPollInterval    = 2 * time.Second
+)
+
+// handledEventTypes are the event types for which nodes are marked, ordered by severity.
+var handledEventTypes = []EventType{
+	EventTypePreempt,
+	EventTypeRedeploy,
+	EventTypeReboot,
+	EventTypeFreeze,
+}
+
+// Agent marks the node it is running on ahead of scheduled events, and optionally
+// acknowledges the events after the node has been drained.
+type Agent struct {
+	nodeName     string
+	kubeClient   clientset.Interface
+	metadata     *imds.Client
+	eventsClient *Client
+
+	// vmName is the name of the VM, which is got from instance metadata on the first sync.
+	vmName string
+
+	// acknowledge enables draining the node and acknowledging the events.
+	acknowledge       bool
+	drainTimeout      time.Duration
+	drainPollInterval time.Duration
+
+	// lock guards acknowledged and draining, which are also accessed by the drain goroutine.
+	lock         sync.Mutex
+	acknowledged sets.String
+	draining     bool
+}
+
+// NewAgent returns a new scheduled events agent for the node.
+func NewAgent(
+	nodeName string,
+	kubeClient clientset.Interface,
+	metadata *imds.Client,
+	eventsClient *Client,
+	acknowledge bool,
+	drainTimeout time.Duration) *Agent {
+	return &Agent{
+		nodeName:     nodeName,
+		kubeClient:   kubeClient,
+		metadata:     metadata,
+		eventsClient: eventsClient,
+		acknowledge:  acknowledge,
+		drainTimeout: drainTimeout,
+		acknowledged: sets.NewString(),
+
+		drainPollInterval: drainPollInterval,
+	}
+}
+
+// Run polls the scheduled events every pollInterval until stopCh is closed.
+func (a *Agent) Run(pollInterval time.Duration, stopCh <-chan struct{}) {
+	klog.Infof("Starting scheduled events agent for node %q", a.nodeName)
+	defer klog.Infof("Shutting down scheduled events agent for node %q", a.nodeName)
+
+	wait.Until(func() {
+		if err := a.sync(); err != nil {
+			klog.Errorf("Failed to sync scheduled events for node %q: %v", a.nodeName, err)
+		}
+	}, pollInterval, stopCh)
+}
+
+func (a *Agent) sync() error {
+	if a.vmName == "" {
+		metadata, err := a.metadata.GetInstanceMetadata()
+		if err != nil {
+			return err
+		}
+		if metadata.Compute == nil || metadata.Compute.Name == "" {
+			return fmt.Errorf("failure of getting VM name from instance metadata")
+		}
+		a.vmName = metadata.Compute.Name
+	}
+
+	events, err := a.eventsClient.GetEvents()
+	if err != nil {
+		return err
+	}
+
+	pending := filterEvents(events.Events, a.vmName)
+	if len(pending) == 0 {
+		return a.unmarkNode()
+	}
+
+	if err := a.markNode(pending[0]); err != nil {
+		return err
+	}
+	if !a.acknowledge {
+		return nil
+	}
+	return a.handleEvents(pending)
+}
+
+// handleEvents acknowledges the scheduled events which haven't been acknowledged. Unless
+// all of them are Freeze events, the node is drained first in the background, so that
+// the events are still polled and the node is still marked while draining.
+func (a *Agent) handleEvents(pending []Event) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.draining {
+		return nil
+	}
+
+	var events []Event
+	var eventIDs []string
+	needsDrain := false
+	for _, event := range pending {
+		if event.EventStatus != eventStatusScheduled || a.acknowledged.Has(event.EventID) {
+			continue
+		}
+		events = append(events, event)
+		eventIDs = append(eventIDs, event.EventID)
+		// Freeze only pauses the VM for a few seconds, so the pods are kept.
+		if event.EventType != EventTypeFreeze {
+			needsDrain = true
+		}
+	}
+	if len(eventIDs) == 0 {
+		return nil
+	}
+
+	if !needsDrain {
+		return a.acknowledgeEvents(eventIDs)
+	}
+
+	a.draining = true
+	deadline := getDrainDeadline(events, time.Now(), a.drainTimeout)
+	go func() {
+		defer func() {
+			a.lock.Lock()
+			a.draining = false
+			a.lock.Unlock()
+		}()
+
+		if err := a.drainNode(deadline); err != nil {
+			klog.Errorf("Failed to drain node %q for scheduled events %v: %v", a.nodeName, eventIDs, err)
+			return
+		}
+
+		a.lock.Lock()
+		defer a.lock.Unlock()
+		if err := a.acknowledgeEvents(eventIDs); err != nil {
+			klog.Errorf("Failed to acknowledge scheduled events %v for node %q: %v", eventIDs, a.nodeName, err)
+		}
+	}()
+	return nil
+}
+
+// acknowledgeEvents acknowledges the events. The caller must hold a.lock.
+func (a *Agent) acknowledgeEvents(eventIDs []string) error {
+	klog.V(2).Infof("Acknowledging scheduled events %v for node %q", eventIDs, a.nodeName)
+	if err := a.eventsClient.Acknowledge(eventIDs...); err != nil {
+		return err
+	}
+	a.acknowledged.Insert(eventIDs...)
+	return nil
+}
+
+// getDrainDeadline returns the time by which the node should be drained, which is the earliest
+// NotBefore of the events, capped by the drain timeout. E.g. Preempt events are only scheduled
+// about 30 seconds ahead, and start at their NotBefore whether or not they are acknowledged.
+func getDrainDeadline(events []Event, now time.Time, drainTimeout time.Duration) time.Time {
+	deadline := now.Add(drainTimeout)
+	for _, event := range events {
+		notBefore, err := time.Parse(http.TimeFormat, event.NotBefore)
+		if err != nil {
+			continue
+		}
+		if notBefore.Before(deadline) {
+			deadline = notBefore
+		}
+	}
+	return deadline
+}
+
+// filterEvents returns the handled events for the VM, ordered by severity.
+func filterEvents(events []Event, vmName string) []Event {
+	var result []Event
+	for _, eventType := range handledEventTypes {
+		for _, event := range events {
+			if event.EventType != eventType {
+				continue
+			}
+			for _, resource := range event.Resources {
+				if strings.EqualFold(resource, vmName) {
+					result = append(result, event)
+					break
+				}
+			}
+		}
+	}
+
+	return result
+}
+
+// markNode taints the node and sets its scheduled event condition. The taint and the
+// condition are checked on every sync, so they are set again if they are removed while
+// the event is pending, but the node is only patched when they differ from the event.
+func (a *Agent) markNode(event Event) error {
+	node, err := a.kubeClient.CoreV1().Nodes().Get(a.nodeName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+
+	taint := &v1.Taint{
+		Key:    TaintKeyScheduledEvent,
+		Value:  string(event.EventType),
+		Effect: v1.TaintEffectNoSchedule,
+	}
+	if current := getNodeTaint(node); current == nil || current.Value != taint.Value {
+		if err := controller.AddOrUpdateTaintOnNode(a.kubeClient, a.nodeName, taint); err != nil {
+			return err
+		}
+	}
+
+	condition := v1.NodeCondition{
+		Type:               NodeConditionScheduledEvent,
+		Status:             v1.ConditionTrue,
+		Reason:             string(event.EventType),
+		Message:            fmt.Sprintf("Scheduled event %s is not before %s", event.EventID, event.NotBefore),
+		LastTransitionTime: metav1.Now(),
+	}
+	current := getNodeCondition(node)
+	if current == nil || current.Status != condition.Status || current.Reason != condition.Reason {
+		if current != nil && current.Status == condition.Status {
+			condition.LastTransitionTime = current.LastTransitionTime
+		}
+		if err := nodeutil.SetNodeCondition(a.kubeClient, types.NodeName(a.nodeName), condition); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unmarkNode removes the taint and clears the scheduled event condition if they have been set.
+func (a *Agent) unmarkNode() error {
+	node, err := a.kubeClient.CoreV1().Nodes().Get(a.nodeName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+
+	if current := getNodeTaint(node); current != nil {
+		if err := controller.RemoveTaintOffNode(a.kubeClient, a.nodeName, node, current); err != nil {
+			return err
+		}
+	}
+
+	if current := getNodeCondition(node); current != nil && current.Status == v1.ConditionTrue {
+		err := nodeutil.SetNodeCondition(a.kubeClient, types.NodeName(a.nodeName), v1.NodeCondition{
+			Type:               NodeConditionScheduledEvent,
+			Status:             v1.ConditionFalse,
+			Reason:             "NoScheduledEvents",
+			Message:            "There are no pending scheduled events",
+			LastTransitionTime: metav1.Now(),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	a.lock.Lock()
+	a.acknowledged = sets.NewString()
+	a.lock.Unlock()
+	return nil
+}
+
+// getNodeTaint returns the scheduled event taint of the node, or nil if it is not set.
+func getNodeTaint(node *v1.Node) *v1.Taint {
+	for i := range node.Spec.Taints {
+		if node.Spec.Taints[i].Key == TaintKeyScheduledEvent {
+			return &node.Spec.Taints[i]
+		}
+	}
+	return nil
+}
+
+// getNodeCondition returns the scheduled event condition of the node, or nil if it is not set.
+func getNodeCondition(node *v1.Node) *v1.NodeCondition {
+	for i := range node.Status.Conditions {
+		if node.Status.Conditions[i].Type == NodeConditionScheduledEvent {
+			return &node.Status.Conditions[i]
+		}
+	}
+	return nil
+}
+
+// drainNode evicts all pods from the node except mirror and DaemonSet pods, and waits
+// until they are deleted or the deadline is reached.
+func (a *Agent) drainNode(deadline time.Time) error {
+	pods, err := a.listEvictablePods()
+	if err != nil {
+		return err
+	}
+
+	klog.V(2).Infof("Draining %d pods from node %q", len(pods), a.nodeName)
+	for _, pod := range pods {
+		err := a.kubeClient.PolicyV1beta1().Evictions(pod.Namespace).Evict(&policy.Eviction{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      pod.Name,
+				Namespace: pod.Namespace,
+			},
+		})
+		if err != nil && !errors.IsNotFound(err) {
+			// Pods protected by PodDisruptionBudget are retried in the next sync.
+			klog.Warningf("Failed to evict pod %s/%s: %v", pod.Namespace, pod.Name, err)
+		}
+	}
+
+	drained := func() (bool, error) {
+		pods, err := a.listEvictablePods()
+		if err != nil {
+			return false, err
+		}
+		return len(pods) == 0, nil
+	}
+
+	// wait.PollImmediate doesn't time out with a zero timeout.
+	timeout := deadline.Sub(time.Now())
+	if timeout <= 0 {
+		done, err := drained()
+		if err == nil && !done {
+			err = wait.ErrWaitTimeout
+		}
+		return err
+	}
+	return wait.PollImmediate(a.drainPollInterval, timeout, drained)
+}
+
+func (a *Agent) listEvictablePods() ([]v1.Pod, error) {
+	podList, err := a.kubeClient.CoreV1().Pods(metav1.NamespaceAll).List(metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("spec.nodeName", a.nodeName).String(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var pods []v1.Pod
+	for _, pod := range podList.Items {
+		if isEvictable(&pod) {
+			pods = append(pods, pod)
+		}
+	}
+	return pods, nil
+}
+
+// isEvictable returns false for mirror pods, DaemonSet pods and terminated pods.
+func isEvictable(pod *v1.Pod) bool {
+	if _, ok := pod.Annotations[mirrorPodAnnotation]; ok {
+		return false
+	}
+	if pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed {
+		return false
+	}
+	if controllerRef := metav1.GetControllerOf(pod); controllerRef != nil && controllerRef.Kind == "DaemonSet" {
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,406 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduledevents
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes/fake"
+	core "k8s.io/client-go/testing"
+	"k8s.io/cloud-provider-azure/cloud-controller-manager/imds"
+)
+
+// fakeMetadataServer serves the instance metadata of VM "vm1" and its scheduled events,
+// and records the acknowledged events.
+type fakeMetadataServer struct {
+	*httptest.Server
+
+	lock         sync.Mutex
+	events       []Event
+	acknowledged []string
+}
+
+func newFakeMetadataServer(t *testing.T) *fakeMetadataServer {
+	f := &fakeMetadataServer{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metadata/instance", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("api-version") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error": "Bad request", "newest-versions": ["2019-03-11"]}`)
+			return
+		}
+		fmt.Fprint(w, `{"compute": {"name": "vm1"}}`)
+	})
+	mux.HandleFunc("/metadata/scheduledevents", func(w http.ResponseWriter, r *http.Request) {
+		f.lock.Lock()
+		defer f.lock.Unlock()
+		if r.Method == "POST" {
+			requests := startRequests{}
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&requests))
+			for _, request := range requests.StartRequests {
+				f.acknowledged = append(f.acknowledged, request.EventID)
+			}
+			return
+		}
+		assert.NoError(t, json.NewEncoder(w).Encode(ScheduledEvents{Events: f.events}))
+	})
+	f.Server = httptest.NewServer(mux)
+	return f
+}
+
+func (f *fakeMetadataServer) setEvents(events ...Event) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.events = events
+}
+
+func (f *fakeMetadataServer) getAcknowledged() []string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return append([]string(nil), f.acknowledged...)
+}
+
+func newTestAgent(server *fakeMetadataServer, acknowledge bool, objects ...runtime.Object) (*Agent, *fake.Clientset) {
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1"}}
+	kubeClient := fake.NewSimpleClientset(append(objects, node)...)
+
+	// The object tracker of the fake clientset unmarshals patched objects into the stored
+	// ones, so taints removed by a patch are kept. Remove them like the API server does.
+	objectReactor := kubeClient.ReactionChain[0]
+	kubeClient.PrependReactor("patch", "nodes", func(action core.Action) (bool, runtime.Object, error) {
+		handled, obj, err := objectReactor.React(action)
+		if err != nil || !bytes.Contains(action.(core.PatchAction).GetPatch(), []byte(`"taints":null`)) {
+			return handled, obj, err
+		}
+		node := obj.(*v1.Node)
+		node.Spec.Taints = nil
+		return objectReactor.React(core.NewRootUpdateAction(action.GetResource(), node))
+	})
+
+	agent := NewAgent(
+		"node1",
+		kubeClient,
+		imds.NewClient(server.URL, imds.AttestationOptions{}),
+		NewClient(server.URL+"/metadata/scheduledevents"),
+		acknowledge,
+		time.Minute)
+	agent.drainPollInterval = 10 * time.Millisecond
+	return agent, kubeClient
+}
+
+func getNode(t *testing.T, kubeClient *fake.Clientset) *v1.Node {
+	node, err := kubeClient.CoreV1().Nodes().Get("node1", metav1.GetOptions{})
+	assert.NoError(t, err)
+	return node
+}
+
+func countNodeWrites(kubeClient *fake.Clientset) int {
+	count := 0
+	for _, action := range kubeClient.Actions() {
+		if action.GetResource().Resource == "nodes" && (action.GetVerb() == "patch" || action.GetVerb() == "update") {
+			count++
+		}
+	}
+	return count
+}
+
+func newEvent(id string, eventType EventType, notBefore time.Time) Event {
+	return Event{
+		EventID:      id,
+		EventType:    eventType,
+		ResourceType: "VirtualMachine",
+		Resources:    []string{"vm1"},
+		EventStatus:  eventStatusScheduled,
+		NotBefore:    notBefore.UTC().Format(http.TimeFormat),
+	}
+}
+
+func TestFilterEvents(t *testing.T) {
+	freeze := Event{EventID: "1", EventType: EventTypeFreeze, Resources: []string{"vm1", "vm2"}}
+	preempt := Event{EventID: "2", EventType: EventTypePreempt, Resources: []string{"VM1"}}
+	reboot := Event{EventID: "3", EventType: EventTypeReboot, Resources: []string{"vm2"}}
+	unknown := Event{EventID: "4", EventType: "Unknown", Resources: []string{"vm1"}}
+
+	testCases := []struct {
+		desc     string
+		vmName   string
+		expected []Event
+	}{
+		{
+			desc:     "events should be ordered by severity",
+			vmName:   "vm1",
+			expected: []Event{preempt, freeze},
+		},
+		{
+			desc:     "only events of the VM should be returned",
+			vmName:   "vm2",
+			expected: []Event{reboot, freeze},
+		},
+		{
+			desc:   "no events should be returned for other VMs",
+			vmName: "vm3",
+		},
+	}
+
+	for _, test := range testCases {
+		result := filterEvents([]Event{freeze, preempt, reboot, unknown}, test.vmName)
+		assert.Equal(t, test.expected, result, test.desc)
+	}
+}
+
+func TestIsEvictable(t *testing.T) {
+	isController := true
+	testCases := []struct {
+		desc     string
+		pod      *v1.Pod
+		expected bool
+	}{
+		{
+			desc:     "running pods should be evictable",
+			pod:      &v1.Pod{Status: v1.PodStatus{Phase: v1.PodRunning}},
+			expected: true,
+		},
+		{
+			desc: "mirror pods should not be evictable",
+			pod: &v1.Pod{ObjectMeta: metav1.ObjectMeta{
+				Annotations: map[string]string{mirrorPodAnnotation: "hash"},
+			}},
+		},
+		{
+			desc: "DaemonSet pods should not be evictable",
+			pod: &v1.Pod{ObjectMeta: metav1.ObjectMeta{
+				OwnerReferences: []metav1.OwnerReference{{Kind: "DaemonSet", Controller: &isController}},
+			}},
+		},
+		{
+			desc: "succeeded pods should not be evictable",
+			pod:  &v1.Pod{Status: v1.PodStatus{Phase: v1.PodSucceeded}},
+		},
+	}
+
+	for _, test := range testCases {
+		assert.Equal(t, test.expected, isEvictable(test.pod), test.desc)
+	}
+}
+
+func TestSyncMarksAndUnmarksNode(t *testing.T) {
+	server := newFakeMetadataServer(t)
+	defer server.Close()
+	agent, kubeClient := newTestAgent(server, false)
+
+	// Nodes without scheduled events are not patched.
+	assert.NoError(t, agent.sync())
+	assert.NoError(t, agent.sync())
+	assert.Equal(t, 0, countNodeWrites(kubeClient))
+	assert.Nil(t, getNodeCondition(getNode(t, kubeClient)))
+
+	server.setEvents(newEvent("1", EventTypeReboot, time.Now().Add(10*time.Minute)))
+	assert.NoError(t, agent.sync())
+	node := getNode(t, kubeClient)
+	assert.Equal(t, []v1.Taint{{Key: TaintKeyScheduledEvent, Value: "Reboot", Effect: v1.TaintEffectNoSchedule}}, node.Spec.Taints)
+	condition := getNodeCondition(node)
+	assert.Equal(t, v1.ConditionTrue, condition.Status)
+	assert.Equal(t, "Reboot", condition.Reason)
+	transitionTime := condition.LastTransitionTime
+	writes := countNodeWrites(kubeClient)
+	assert.Equal(t, 2, writes)
+
+	// The node is not patched again while the event is pending.
+	assert.NoError(t, agent.sync())
+	assert.NoError(t, agent.sync())
+	assert.Equal(t, writes, countNodeWrites(kubeClient))
+
+	// A more severe event updates the reason, but keeps the transition time.
+	server.setEvents(
+		newEvent("1", EventTypeReboot, time.Now().Add(10*time.Minute)),
+		newEvent("2", EventTypeRedeploy, time.Now().Add(10*time.Minute)))
+	assert.NoError(t, agent.sync())
+	node = getNode(t, kubeClient)
+	assert.Equal(t, "Redeploy", node.Spec.Taints[0].Value)
+	condition = getNodeCondition(node)
+	assert.Equal(t, "Redeploy", condition.Reason)
+	assert.Equal(t, transitionTime, condition.LastTransitionTime)
+
+	server.setEvents()
+	assert.NoError(t, agent.sync())
+	node = getNode(t, kubeClient)
+	assert.Empty(t, node.Spec.Taints)
+	condition = getNodeCondition(node)
+	assert.Equal(t, v1.ConditionFalse, condition.Status)
+	writes = countNodeWrites(kubeClient)
+	assert.NoError(t, agent.sync())
+	assert.Equal(t, writes, countNodeWrites(kubeClient))
+	assert.Empty(t, server.getAcknowledged())
+}
+
+func TestSyncDoesNotRewriteMarkedNode(t *testing.T) {
+	server := newFakeMetadataServer(t)
+	defer server.Close()
+	agent, kubeClient := newTestAgent(server, false)
+
+	// The node has been marked before the agent restarted.
+	node := getNode(t, kubeClient)
+	node.Spec.Taints = []v1.Taint{{Key: TaintKeyScheduledEvent, Value: "Reboot", Effect: v1.TaintEffectNoSchedule}}
+	node.Status.Conditions = []v1.NodeCondition{{Type: NodeConditionScheduledEvent, Status: v1.ConditionTrue, Reason: "Reboot"}}
+	_, err := kubeClient.CoreV1().Nodes().Update(node)
+	assert.NoError(t, err)
+	kubeClient.ClearActions()
+
+	server.setEvents(newEvent("1", EventTypeReboot, time.Now().Add(10*time.Minute)))
+	assert.NoError(t, agent.sync())
+	assert.NoError(t, agent.sync())
+	assert.Equal(t, 0, countNodeWrites(kubeClient))
+}
+
+func TestSyncRemarksNodeAfterExternalChanges(t *testing.T) {
+	server := newFakeMetadataServer(t)
+	defer server.Close()
+	agent, kubeClient := newTestAgent(server, false)
+
+	server.setEvents(newEvent("1", EventTypeReboot, time.Now().Add(10*time.Minute)))
+	assert.NoError(t, agent.sync())
+
+	// The taint and the condition are removed by someone else while the event is pending.
+	node := getNode(t, kubeClient)
+	node.Spec.Taints = nil
+	node.Status.Conditions = nil
+	_, err := kubeClient.CoreV1().Nodes().Update(node)
+	assert.NoError(t, err)
+
+	assert.NoError(t, agent.sync())
+	node = getNode(t, kubeClient)
+	assert.Equal(t, []v1.Taint{{Key: TaintKeyScheduledEvent, Value: "Reboot", Effect: v1.TaintEffectNoSchedule}}, node.Spec.Taints)
+	condition := getNodeCondition(node)
+	if assert.NotNil(t, condition) {
+		assert.Equal(t, v1.ConditionTrue, condition.Status)
+		assert.Equal(t, "Reboot", condition.Reason)
+	}
+}
+
+func TestSyncAcknowledgesFreezeWithoutDrain(t *testing.T) {
+	server := newFakeMetadataServer(t)
+	defer server.Close()
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod1", Namespace: "default"},
+		Spec:       v1.PodSpec{NodeName: "node1"},
+		Status:     v1.PodStatus{Phase: v1.PodRunning},
+	}
+	agent, kubeClient := newTestAgent(server, true, pod)
+
+	server.setEvents(newEvent("1", EventTypeFreeze, time.Now().Add(10*time.Minute)))
+	assert.NoError(t, agent.sync())
+	assert.Equal(t, []string{"1"}, server.getAcknowledged())
+	for _, action := range kubeClient.Actions() {
+		assert.NotEqual(t, "eviction", action.GetSubresource())
+	}
+
+	// Acknowledged events are not acknowledged again.
+	assert.NoError(t, agent.sync())
+	assert.Equal(t, []string{"1"}, server.getAcknowledged())
+}
+
+func TestSyncDrainsNodeInBackground(t *testing.T) {
+	server := newFakeMetadataServer(t)
+	defer server.Close()
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod1", Namespace: "default"},
+		Spec:       v1.PodSpec{NodeName: "node1"},
+		Status:     v1.PodStatus{Phase: v1.PodRunning},
+	}
+	agent, kubeClient := newTestAgent(server, true, pod)
+
+	server.setEvents(newEvent("1", EventTypePreempt, time.Now().Add(30*time.Second)))
+	assert.NoError(t, agent.sync())
+	// The sync loop is not blocked by the drain.
+	assert.NoError(t, agent.sync())
+	assert.Equal(t, "Preempt", getNodeCondition(getNode(t, kubeClient)).Reason)
+	assert.Empty(t, server.getAcknowledged())
+
+	// The pod is evicted, but it is only deleted when its containers terminated.
+	evicted := false
+	for _, action := range kubeClient.Actions() {
+		evicted = evicted || action.GetSubresource() == "eviction"
+	}
+	assert.True(t, evicted)
+	assert.NoError(t, kubeClient.CoreV1().Pods("default").Delete("pod1", nil))
+	assert.NoError(t, wait.PollImmediate(10*time.Millisecond, 5*time.Second, func() (bool, error) {
+		return len(server.getAcknowledged()) > 0, nil
+	}))
+	assert.Equal(t, []string{"1"}, server.getAcknowledged())
+}
+
+func TestDrainNodeTimesOutAtDeadline(t *testing.T) {
+	server := newFakeMetadataServer(t)
+	defer server.Close()
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod1", Namespace: "default"},
+		Spec:       v1.PodSpec{NodeName: "node1"},
+		Status:     v1.PodStatus{Phase: v1.PodRunning},
+	}
+	agent, kubeClient := newTestAgent(server, true, pod)
+	kubeClient.PrependReactor("create", "pods", func(action core.Action) (bool, runtime.Object, error) {
+		// The eviction is rejected by a PodDisruptionBudget.
+		return action.GetSubresource() == "eviction", nil, fmt.Errorf("too many requests")
+	})
+
+	assert.Equal(t, wait.ErrWaitTimeout, agent.drainNode(time.Now().Add(-time.Second)))
+	assert.Equal(t, wait.ErrWaitTimeout, agent.drainNode(time.Now().Add(50*time.Millisecond)))
+}
+
+func TestGetDrainDeadline(t *testing.T) {
+	now := time.Date(2019, 6, 1, 10, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		desc     string
+		events   []Event
+		expected time.Time
+	}{
+		{
+			desc:     "deadline should be capped by the drain timeout",
+			events:   []Event{newEvent("1", EventTypeReboot, now.Add(time.Hour))},
+			expected: now.Add(10 * time.Minute),
+		},
+		{
+			desc: "deadline should be the earliest NotBefore",
+			events: []Event{
+				newEvent("1", EventTypeReboot, now.Add(5*time.Minute)),
+				newEvent("2", EventTypePreempt, now.Add(30*time.Second)),
+			},
+			expected: now.Add(30 * time.Second),
+		},
+		{
+			desc:     "invalid NotBefore should be ignored",
+			events:   []Event{{EventID: "1", NotBefore: ""}},
+			expected: now.Add(10 * time.Minute),
+		},
+	}
+
+	for _, test := range testCases {
+		assert.True(t, test.expected.Equal(getDrainDeadline(test.events, now, 10*time.Minute)), test.desc)
+	}
+}
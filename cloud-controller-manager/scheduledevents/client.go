@@ -0,0 +1,164 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduledevents
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+const (
+	// ScheduledEventsURL is the endpoint of Azure instance metadata service for scheduled events.
+	ScheduledEventsURL = "http://169.254.169.254/metadata/scheduledevents"
+
+	scheduledEventsAPIVersion = "2017-11-01"
+	requestTimeout            = 10 * time.Second
+)
+
+// EventType is the type of a scheduled event.
+type EventType string
+
+const (
+	// EventTypeFreeze means the VM is scheduled to pause for a few seconds.
+	EventTypeFreeze EventType = "Freeze"
+	// EventTypeReboot means the VM is scheduled for reboot.
+	EventTypeReboot EventType = "Reboot"
+	// EventTypeRedeploy means the VM is scheduled to move to another node, ephemeral disks are lost.
+	EventTypeRedeploy EventType = "Redeploy"
+	// EventTypePreempt means the low priority VM is being deleted.
+	EventTypePreempt EventType = "Preempt"
+)
+
+// Event is a scheduled event for one or more VMs.
+type Event struct {
+	EventID      string    `json:"EventId"`
+	EventType    EventType `json:"EventType"`
+	ResourceType string    `json:"ResourceType"`
+	Resources    []string  `json:"Resources"`
+	EventStatus  string    `json:"EventStatus"`
+	NotBefore    string    `json:"NotBefore"`
+}
+
+// ScheduledEvents is the document returned by the scheduled events endpoint.
+type ScheduledEvents struct {
+	DocumentIncarnation int     `json:"DocumentIncarnation"`
+	Events              []Event `json:"Events"`
+}
+
+type startRequest struct {
+	EventID string `json:"EventId"`
+}
+
+type startRequests struct {
+	StartRequests []startRequest `json:"StartRequests"`
+}
+
+// Client knows how to query and acknowledge scheduled events from Azure instance metadata service.
+type Client struct {
+	url        string
+	httpClient *http.Client
+}
+
+// NewClient returns a new scheduled events client for the given endpoint.
+func NewClient(url string) *Client {
+	return &Client{
+		url:        url,
+		httpClient: &http.Client{Timeout: requestTimeout},
+	}
+}
+
+// GetEvents gets the current scheduled events.
+func (c *Client) GetEvents() (*ScheduledEvents, error) {
+	req, err := c.newRequest("GET", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	events := ScheduledEvents{}
+	if err := json.Unmarshal(data, &events); err != nil {
+		return nil, err
+	}
+	return &events, nil
+}
+
+// Acknowledge approves the events, so that they could start before their NotBefore time.
+func (c *Client) Acknowledge(eventIDs ...string) error {
+	if len(eventIDs) == 0 {
+		return nil
+	}
+
+	body := startRequests{}
+	for _, id := range eventIDs {
+		body.StartRequests = append(body.StartRequests, startRequest{EventID: id})
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := c.newRequest("POST", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	_, err = c.do(req)
+	return err
+}
+
+func (c *Client) newRequest(method string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, c.url, body)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Add("Metadata", "True")
+	req.Header.Add("User-Agent", "golang/kubernetes-cloud-provider")
+
+	q := req.URL.Query()
+	q.Add("api-version", scheduledEventsAPIVersion)
+	req.URL.RawQuery = q.Encode()
+	return req, nil
+}
+
+func (c *Client) do(req *http.Request) ([]byte, error) {
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failure of %s scheduled events with response %q: %s", req.Method, resp.Status, string(data))
+	}
+
+	return data, nil
+}
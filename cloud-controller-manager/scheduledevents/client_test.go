@@ -0,0 +1,80 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduledevents
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetEvents(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "True", r.Header.Get("Metadata"))
+		assert.Equal(t, scheduledEventsAPIVersion, r.URL.Query().Get("api-version"))
+		fmt.Fprint(w, `{"DocumentIncarnation":2,"Events":[{"EventId":"602d9444-d2cd-49c7-8624-8643e7171297","EventType":"Preempt","ResourceType":"VirtualMachine","Resources":["ss_1"],"EventStatus":"Scheduled","NotBefore":"Mon, 19 Sep 2016 18:29:47 GMT"}]}`)
+	}))
+	defer server.Close()
+
+	events, err := NewClient(server.URL).GetEvents()
+	assert.NoError(t, err)
+	assert.Equal(t, &ScheduledEvents{
+		DocumentIncarnation: 2,
+		Events: []Event{
+			{
+				EventID:      "602d9444-d2cd-49c7-8624-8643e7171297",
+				EventType:    EventTypePreempt,
+				ResourceType: "VirtualMachine",
+				Resources:    []string{"ss_1"},
+				EventStatus:  "Scheduled",
+				NotBefore:    "Mon, 19 Sep 2016 18:29:47 GMT",
+			},
+		},
+	}, events)
+}
+
+func TestGetEventsError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	_, err := NewClient(server.URL).GetEvents()
+	assert.Error(t, err)
+}
+
+func TestAcknowledge(t *testing.T) {
+	var received startRequests
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "POST", r.Method)
+		body, err := ioutil.ReadAll(r.Body)
+		assert.NoError(t, err)
+		assert.NoError(t, json.Unmarshal(body, &received))
+	}))
+	defer server.Close()
+
+	err := NewClient(server.URL).Acknowledge("event1", "event2")
+	assert.NoError(t, err)
+	assert.Equal(t, startRequests{
+		StartRequests: []startRequest{{EventID: "event1"}, {EventID: "event2"}},
+	}, received)
+}
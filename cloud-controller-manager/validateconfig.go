@@ -0,0 +1,120 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"k8s.io/cloud-provider-azure/cloud-controller-manager/cloudconfig"
+	"sigs.k8s.io/yaml"
+)
+
+// validateConfigOptions holds the options of the validate-config command.
+type validateConfigOptions struct {
+	CloudConfig string
+	Output      string
+	// Strict fails the validation if unversioned configs have unknown fields.
+	Strict bool
+}
+
+// newValidateConfigCommand returns the command which validates a cloud config file.
+func newValidateConfigCommand() *cobra.Command {
+	o := &validateConfigOptions{
+		Output: "yaml",
+	}
+
+	cmd := &cobra.Command{
+		Use:   "validate-config",
+		Short: "Validate a cloud config file",
+		Long: `Validate a cloud config file. The config is parsed and defaulted in the same way as the Azure
+cloud provider does, and checked for consistency. Versioned configs are decoded strictly, and
+warnings are printed for unversioned configs, e.g. for unknown or misspelled fields. The
+normalized config is printed in the latest version with secrets redacted. The command exits
+with a non-zero code if the config is invalid, or with --strict if it has unknown fields.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := runValidateConfig(o, os.Stdout, os.Stderr); err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+				os.Exit(1)
+			}
+		},
+	}
+
+	fs := cmd.Flags()
+	fs.StringVar(&o.CloudConfig, "cloud-config", o.CloudConfig, "The path to the cloud config file to validate.")
+	fs.StringVarP(&o.Output, "output", "o", o.Output, "Output format of the normalized config, one of 'yaml' or 'json'.")
+	fs.BoolVar(&o.Strict, "strict", o.Strict, "Fail if an unversioned config has unknown fields, which are otherwise only warned about.")
+
+	return cmd
+}
+
+// runValidateConfig writes the normalized config to out and the warnings to errOut.
+func runValidateConfig(o *validateConfigOptions, out, errOut io.Writer) error {
+	if o.CloudConfig == "" {
+		return fmt.Errorf("--cloud-config is required")
+	}
+	if o.Output != "yaml" && o.Output != "json" {
+		return fmt.Errorf("invalid --output %q, must be 'yaml' or 'json'", o.Output)
+	}
+
+	data, err := ioutil.ReadFile(o.CloudConfig)
+	if err != nil {
+		return err
+	}
+	loaded, err := cloudconfig.Merge(cloudconfig.Layer{Source: cloudconfig.SourceFile, Data: data})
+	if err != nil {
+		return err
+	}
+	for _, warning := range loaded.Warnings {
+		fmt.Fprintf(errOut, "Warning: %s\n", warning)
+	}
+	v1 := cloudconfig.ConvertToV1(loaded.Config, loaded.Environment)
+	cloudconfig.SetDefaultsV1(v1)
+	config, environment := cloudconfig.ConvertFromV1(v1)
+
+	fields, err := cloudconfig.Redact(config, environment)
+	if err != nil {
+		return err
+	}
+	fields["apiVersion"] = v1.APIVersion
+	var normalized []byte
+	if o.Output == "json" {
+		normalized, err = json.MarshalIndent(fields, "", "    ")
+		normalized = append(normalized, '\n')
+	} else {
+		normalized, err = yaml.Marshal(fields)
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := out.Write(normalized); err != nil {
+		return err
+	}
+
+	if errs := cloudconfig.Validate(config, environment); len(errs) > 0 {
+		return fmt.Errorf("cloud config %q is invalid: %v", o.CloudConfig, errs.ToAggregate())
+	}
+	if o.Strict && len(loaded.UnknownFields) > 0 {
+		return fmt.Errorf("cloud config %q has unknown fields %q", o.CloudConfig, loaded.UnknownFields)
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeTestFile writes data to a file in a new temporary directory, which is removed by the
+// returned function.
+func writeTestFile(t *testing.T, name, data string) (string, func()) {
+	dir, err := ioutil.TempDir("", "azure-cloud-controller-manager")
+	assert.NoError(t, err)
+	path := filepath.Join(dir, name)
+	assert.NoError(t, ioutil.WriteFile(path, []byte(data), 0600))
+	return path, func() { os.RemoveAll(dir) }
+}
+
+func TestRunValidateConfig(t *testing.T) {
+	testCases := []struct {
+		desc             string
+		config           string
+		output           string
+		strict           bool
+		expectedOutput   []string
+		expectedWarnings []string
+		expectedErr      string
+	}{
+		{
+			desc:           "valid config should be normalized with secrets redacted",
+			config:         `{"tenantId": "tenant", "subscriptionId": "sub", "aadClientId": "client", "aadClientSecret": "secret", "resourceGroup": "RG", "location": "westus2"}`,
+			output:         "yaml",
+			expectedOutput: []string{"apiVersion: cloudprovider.azure.k8s.io/v1\n", "aadClientSecret: REDACTED\n", "resourceGroup: rg\n"},
+		},
+		{
+			desc:             "unknown field of unversioned config should only be warned",
+			config:           `{"tenantId": "tenant", "subscriptionId": "sub", "aadClientId": "client", "aadClientSecret": "secret", "resourceGrop": "rg", "resourceGroup": "rg", "location": "westus2"}`,
+			output:           "yaml",
+			expectedOutput:   []string{"resourceGroup: rg\n"},
+			expectedWarnings: []string{`Warning: unknown field "resourceGrop"`},
+		},
+		{
+			desc:             "unknown field of unversioned config should fail with strict",
+			config:           `{"tenantId": "tenant", "subscriptionId": "sub", "aadClientId": "client", "aadClientSecret": "secret", "resourceGrop": "rg", "resourceGroup": "rg", "location": "westus2"}`,
+			output:           "yaml",
+			strict:           true,
+			expectedOutput:   []string{"resourceGroup: rg\n"},
+			expectedWarnings: []string{`Warning: unknown field "resourceGrop"`},
+			expectedErr:      `unknown fields ["resourceGrop"]`,
+		},
+		{
+			desc:        "unknown field of versioned config should fail",
+			config:      `{"apiVersion": "cloudprovider.azure.k8s.io/v1", "resourceGrop": "rg"}`,
+			output:      "yaml",
+			expectedErr: `unknown field "resourceGrop"`,
+		},
+		{
+			desc:           "invalid config should fail after printing the normalized config",
+			config:         `{"subscriptionId": "sub", "aadClientId": "client", "aadClientSecret": "secret", "resourceGroup": "rg", "location": "westus2"}`,
+			output:         "json",
+			expectedOutput: []string{`"subscriptionId": "sub"`},
+			expectedErr:    "tenantId: Required value",
+		},
+		{
+			desc:        "invalid output should fail",
+			config:      `{}`,
+			output:      "xml",
+			expectedErr: `invalid --output "xml"`,
+		},
+	}
+
+	for _, test := range testCases {
+		path, cleanup := writeTestFile(t, "azure.json", test.config)
+		out, errOut := &bytes.Buffer{}, &bytes.Buffer{}
+
+		err := runValidateConfig(&validateConfigOptions{CloudConfig: path, Output: test.output, Strict: test.strict}, out, errOut)
+		if test.expectedErr != "" {
+			if assert.Error(t, err, test.desc) {
+				assert.Contains(t, err.Error(), test.expectedErr, test.desc)
+			}
+		} else {
+			assert.NoError(t, err, test.desc)
+		}
+		for _, expected := range test.expectedOutput {
+			assert.Contains(t, out.String(), expected, test.desc)
+		}
+		for _, expected := range test.expectedWarnings {
+			assert.Contains(t, errOut.String(), expected, test.desc)
+		}
+		if test.output == "json" && out.Len() > 0 {
+			assert.NoError(t, json.Unmarshal(out.Bytes(), &map[string]interface{}{}), test.desc)
+		}
+
+		cleanup()
+	}
+}
+
+func TestRunValidateConfigWithoutFile(t *testing.T) {
+	err := runValidateConfig(&validateConfigOptions{Output: "yaml"}, &bytes.Buffer{}, &bytes.Buffer{})
+	assert.EqualError(t, err, "--cloud-config is required")
+}
@@ -18,16 +18,99 @@ package version
 
 import (
 	"fmt"
+	"io"
 	"os"
+	"strconv"
 )
 
 // ApplicationName is name for the app
 const ApplicationName string = "azure-cloud-controller-manager"
 
+// Format is the value of the '--version' flag, which is the format of the version information.
+type Format string
+
+const (
+	// FormatNone doesn't print the version information.
+	FormatNone Format = "false"
+	// FormatDefault prints the version followed by all version information.
+	FormatDefault Format = "true"
+	// FormatRaw prints all version information as a Go value.
+	FormatRaw Format = "raw"
+	// FormatJSON prints all version information in JSON.
+	FormatJSON Format = "json"
+	// FormatYAML prints all version information in YAML.
+	FormatYAML Format = "yaml"
+	// FormatShort prints the version only.
+	FormatShort Format = "short"
+)
+
+// FlagUsage is the usage of the '--version' flag.
+const FlagUsage = "Print version information and quit. The format is one of 'true', 'raw', 'json', 'yaml' or 'short'."
+
+// IsBoolFlag makes '--version' equal to '--version=true'.
+func (f *Format) IsBoolFlag() bool {
+	return true
+}
+
+// Set sets the format, boolean values are accepted for compatibility.
+func (f *Format) Set(s string) error {
+	switch Format(s) {
+	case FormatRaw, FormatJSON, FormatYAML, FormatShort:
+		*f = Format(s)
+		return nil
+	}
+
+	enabled, err := strconv.ParseBool(s)
+	if err != nil {
+		return fmt.Errorf("invalid version format %q, must be one of 'true', 'false', 'raw', 'json', 'yaml' or 'short'", s)
+	}
+	if enabled {
+		*f = FormatDefault
+	} else {
+		*f = FormatNone
+	}
+	return nil
+}
+
+func (f *Format) String() string {
+	return string(*f)
+}
+
+// Type returns the type of the flag.
+func (f *Format) Type() string {
+	return "version"
+}
+
 // PrintAndExit will handle '--version' flag.
-func PrintAndExit() {
-	info := getInfo()
-	fmt.Printf("%s %s\n", ApplicationName, info.Version)
-	fmt.Println(info)
+func PrintAndExit(format Format) {
+	if err := printInfo(os.Stdout, format); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
 	os.Exit(0)
 }
+
+func printInfo(w io.Writer, format Format) error {
+	info := getInfo()
+
+	var output string
+	var err error
+	switch format {
+	case FormatRaw:
+		output = info.String() + "\n"
+	case FormatJSON:
+		output, err = info.JSON()
+	case FormatYAML:
+		output, err = info.YAML()
+	case FormatShort:
+		output = info.Version + "\n"
+	default:
+		output = fmt.Sprintf("%s %s\n%s\n", ApplicationName, info.Version, info)
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(w, output)
+	return err
+}
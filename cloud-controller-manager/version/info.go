@@ -17,19 +17,38 @@ limitations under the License.
 package version
 
 import (
+	"encoding/json"
 	"fmt"
 	"runtime"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2018-10-01/compute"
+	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2017-09-01/network"
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2018-07-01/storage"
+	sdkversion "github.com/Azure/azure-sdk-for-go/version"
+	"sigs.k8s.io/yaml"
 )
 
 var (
-	version   string
-	buildDate string
+	version           string
+	gitCommit         string
+	gitTreeState      string
+	buildDate         string
+	kubernetesVersion string
 )
 
 type info struct {
-	Version   string
-	BuildDate string
-	GoVersion string
+	Version           string `json:"version"`
+	GitCommit         string `json:"gitCommit"`
+	GitTreeState      string `json:"gitTreeState"`
+	BuildDate         string `json:"buildDate"`
+	GoVersion         string `json:"goVersion"`
+	Compiler          string `json:"compiler"`
+	Platform          string `json:"platform"`
+	KubernetesVersion string `json:"kubernetesVersion"`
+	AzureSDKVersion   string `json:"azureSDKVersion"`
+	// AzureSDKAPIVersions maps the Azure SDK services to their API versions.
+	AzureSDKAPIVersions map[string]string `json:"azureSDKAPIVersions"`
 }
 
 // String returns info as a human-friendly version string.
@@ -37,12 +56,47 @@ func (v info) String() string {
 	return fmt.Sprintf("%#v", v)
 }
 
+// JSON returns info in indented JSON.
+func (v info) JSON() (string, error) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(data) + "\n", nil
+}
+
+// YAML returns info in YAML.
+func (v info) YAML() (string, error) {
+	data, err := yaml.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
 func getInfo() info {
 	// These variables typically come from -ldflags settings and in
 	// their absence fallback to the settings in pkg/version/version.go
 	return info{
-		Version:   version,
-		BuildDate: buildDate,
-		GoVersion: runtime.Version(),
+		Version:           version,
+		GitCommit:         gitCommit,
+		GitTreeState:      gitTreeState,
+		BuildDate:         buildDate,
+		GoVersion:         runtime.Version(),
+		Compiler:          runtime.Compiler,
+		Platform:          fmt.Sprintf("%s/%s", runtime.GOOS, runtime.GOARCH),
+		KubernetesVersion: kubernetesVersion,
+		AzureSDKVersion:   sdkversion.Number,
+		AzureSDKAPIVersions: map[string]string{
+			"compute": getAPIVersion(compute.UserAgent()),
+			"network": getAPIVersion(network.UserAgent()),
+			"storage": getAPIVersion(storage.UserAgent()),
+		},
 	}
 }
+
+// getAPIVersion returns the API version from the user agent of an Azure SDK service,
+// e.g. "2018-10-01" for "Azure-SDK-For-Go/v21.3.0 compute/2018-10-01".
+func getAPIVersion(userAgent string) string {
+	return userAgent[strings.LastIndex(userAgent, "/")+1:]
+}
@@ -0,0 +1,39 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package version
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// NewInfoCollector returns a gauge with a constant '1' value labeled by the version information.
+func NewInfoCollector() prometheus.Collector {
+	buildInfo := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "cloudprovider_azure_build_info",
+			Help: "A metric with a constant '1' value labeled by version, git commit, git tree state, build date, Go version, compiler, platform, Kubernetes version, Azure SDK version and API versions from which azure-cloud-controller-manager was built.",
+		},
+		[]string{"version", "gitCommit", "gitTreeState", "buildDate", "goVersion", "compiler", "platform",
+			"kubernetesVersion", "azureSDKVersion", "computeAPIVersion", "networkAPIVersion", "storageAPIVersion"},
+	)
+
+	info := getInfo()
+	buildInfo.WithLabelValues(info.Version, info.GitCommit, info.GitTreeState, info.BuildDate, info.GoVersion, info.Compiler, info.Platform,
+		info.KubernetesVersion, info.AzureSDKVersion,
+		info.AzureSDKAPIVersions["compute"], info.AzureSDKAPIVersions["network"], info.AzureSDKAPIVersions["storage"]).Set(1)
+	return buildInfo
+}
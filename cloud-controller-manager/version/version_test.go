@@ -17,11 +17,97 @@ limitations under the License.
 package version
 
 import (
-	"github.com/stretchr/testify/assert"
+	"bytes"
+	"encoding/json"
+	"strings"
 	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"sigs.k8s.io/yaml"
 )
 
 func TestVersionNotEmpty(t *testing.T) {
 	info := getInfo()
 	assert.NotEmpty(t, info.Version)
 }
+
+func TestAzureSDKAPIVersions(t *testing.T) {
+	info := getInfo()
+	assert.Equal(t, map[string]string{
+		"compute": "2018-10-01",
+		"network": "2017-09-01",
+		"storage": "2018-07-01",
+	}, info.AzureSDKAPIVersions)
+	assert.NotEmpty(t, info.AzureSDKVersion)
+	assert.NotEmpty(t, info.Platform)
+}
+
+func TestFormatSet(t *testing.T) {
+	testCases := []struct {
+		value     string
+		expected  Format
+		expectErr bool
+	}{
+		{value: "true", expected: FormatDefault},
+		{value: "false", expected: FormatNone},
+		{value: "1", expected: FormatDefault},
+		{value: "raw", expected: FormatRaw},
+		{value: "json", expected: FormatJSON},
+		{value: "yaml", expected: FormatYAML},
+		{value: "short", expected: FormatShort},
+		{value: "xml", expectErr: true},
+	}
+
+	for _, test := range testCases {
+		format := FormatNone
+		err := format.Set(test.value)
+		if test.expectErr {
+			assert.Error(t, err, test.value)
+			continue
+		}
+		assert.NoError(t, err, test.value)
+		assert.Equal(t, test.expected, format, test.value)
+	}
+}
+
+func TestPrintInfo(t *testing.T) {
+	expected := getInfo()
+
+	buf := &bytes.Buffer{}
+	assert.NoError(t, printInfo(buf, FormatJSON))
+	fromJSON := info{}
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &fromJSON))
+	assert.Equal(t, expected, fromJSON)
+
+	buf.Reset()
+	assert.NoError(t, printInfo(buf, FormatYAML))
+	fromYAML := info{}
+	assert.NoError(t, yaml.Unmarshal(buf.Bytes(), &fromYAML))
+	assert.Equal(t, expected, fromYAML)
+
+	buf.Reset()
+	assert.NoError(t, printInfo(buf, FormatShort))
+	assert.Equal(t, expected.Version+"\n", buf.String())
+
+	buf.Reset()
+	assert.NoError(t, printInfo(buf, FormatDefault))
+	assert.True(t, strings.HasPrefix(buf.String(), ApplicationName))
+}
+
+func TestInfoCollector(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	assert.NoError(t, registry.Register(NewInfoCollector()))
+
+	families, err := registry.Gather()
+	assert.NoError(t, err)
+	assert.Len(t, families, 1)
+	assert.Equal(t, "cloudprovider_azure_build_info", families[0].GetName())
+
+	labels := make(map[string]string)
+	for _, label := range families[0].GetMetric()[0].GetLabel() {
+		labels[label.GetName()] = label.GetValue()
+	}
+	assert.Equal(t, "2018-10-01", labels["computeAPIVersion"])
+	assert.Equal(t, getInfo().Platform, labels["platform"])
+}
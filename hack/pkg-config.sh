@@ -20,5 +20,14 @@ cd $(dirname "$BASH_SOURCE")/..
 VERSION_PKG=k8s.io/cloud-provider-azure/cloud-controller-manager/version
 LDFLAGS="-s -w"
 LDFLAGS="$LDFLAGS -X $VERSION_PKG.version=$(git describe --tags --always --abbrev=9 || echo)"
+LDFLAGS="$LDFLAGS -X $VERSION_PKG.gitCommit=$(git rev-parse HEAD 2>/dev/null || echo)"
+if GIT_STATUS=$(git status --porcelain 2>/dev/null); then
+    if [ -z "$GIT_STATUS" ]; then
+        LDFLAGS="$LDFLAGS -X $VERSION_PKG.gitTreeState=clean"
+    else
+        LDFLAGS="$LDFLAGS -X $VERSION_PKG.gitTreeState=dirty"
+    fi
+fi
 LDFLAGS="$LDFLAGS -X $VERSION_PKG.buildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+LDFLAGS="$LDFLAGS -X $VERSION_PKG.kubernetesVersion=$(grep -A1 '^- package: k8s.io/kubernetes$' glide.yaml | awk '/version:/ {print $2}')"
 echo -ldflags \'$LDFLAGS\'
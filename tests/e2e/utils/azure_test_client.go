@@ -18,15 +18,12 @@ package utils
 
 import (
 	"fmt"
-	"regexp"
 
 	aznetwork "github.com/Azure/azure-sdk-for-go/services/network/mgmt/2017-09-01/network"
 	"github.com/Azure/go-autorest/autorest"
 	"github.com/Azure/go-autorest/autorest/azure"
-)
 
-var (
-	azureResourceGroupNameRE = regexp.MustCompile(`.*/subscriptions/(?:.*)/resourceGroups/(.+)/providers/(?:.*)`)
+	"k8s.io/cloud-provider-azure/cloud-controller-manager/providerid"
 )
 
 // AzureTestClient configs Azure specific clients
@@ -102,10 +99,10 @@ func (tc *AzureTestClient) createPublicIPAddressesClient() *aznetwork.PublicIPAd
 
 // getResourceGroupFromProviderID gets the resource group name in the provider ID.
 func getResourceGroupFromProviderID(providerID string) (string, error) {
-	matches := azureResourceGroupNameRE.FindStringSubmatch(providerID)
-	if len(matches) != 2 {
-		return "", fmt.Errorf("%q isn't in Azure resource ID format %q", providerID, azureResourceGroupNameRE.String())
+	id, err := providerid.Parse(providerID)
+	if err != nil {
+		return "", err
 	}
 
-	return matches[1], nil
+	return id.ResourceGroup, nil
 }